@@ -1,29 +1,86 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/swagftw/stripe_pay_service/pkg/api"
-	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/bootstrap"
 	"github.com/swagftw/stripe_pay_service/utl/logger"
-	"github.com/swagftw/stripe_pay_service/utl/migration"
 )
 
+const usage = `usage: api [-config file] [-set key=value]... [command]
+
+commands:
+  serve             serve the api, the default
+  migrate up        apply the pending migrations
+  migrate down      revert the last migration, -steps reverts more
+  migrate status    list the migrations and when they were applied
+  migrate create    create the up and down files of a new migration
+  migrate redact-payloads
+                    mask secrets and PII in the stored stripe payloads`
+
+// overrides collects the repeated -set flags.
+type overrides []string
+
+func (o *overrides) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *overrides) Set(value string) error {
+	*o = append(*o, value)
+
+	return nil
+}
+
 func main() {
+	var sets overrides
+
 	cfgPath := flag.String("config", "./utl/config/config.local.yaml", "config file")
+	flag.Var(&sets, "set", "override a config key, such as -set server.port=9090, may be repeated")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	// init log
-	logger.InitLogger()
+	command := flag.Arg(0)
+	if command == "" {
+		command = "serve"
+	}
+
+	// creating a migration only writes files, it needs neither config nor database
+	if command == "migrate" && flag.Arg(1) == "create" {
+		os.Exit(createMigration(flag.Args()[2:]))
+	}
 
-	// init config
-	err := config.InitConfig(*cfgPath, "./.env")
+	// load the config, configure the logger and fetch the secrets
+	err := bootstrap.Init(context.Background(), *cfgPath, sets...)
 	if err != nil {
-		return
+		os.Exit(1)
+	}
+
+	exitCode := 0
+
+	switch command {
+	case "serve":
+		err = api.Start()
+		if err != nil {
+			exitCode = 1
+		}
+	case "migrate":
+		exitCode = migrate(flag.Args()[1:])
+	default:
+		flag.Usage()
+
+		exitCode = 2
 	}
 
-	// run migration before starting server
-	migration.Migrate()
+	// flush buffered log lines before exiting
+	_ = logger.Logger.Sync()
 
-	api.Start()
+	os.Exit(exitCode)
 }
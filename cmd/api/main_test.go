@@ -7,8 +7,11 @@ import (
 	"github.com/swagftw/stripe_pay_service/utl/config"
 )
 
+// encryptionKeyOverride sets the encryption key the config requires, .env leaves it empty.
+const encryptionKeyOverride = "security.encryptionKey=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
 func TestMain(m *testing.M) {
-	err := config.InitConfig("../../utl/config/config.local.yaml", "../../.env")
+	err := config.InitConfig("../../utl/config/config.local.yaml", "../../.env", encryptionKeyOverride)
 	if err != nil {
 		os.Exit(1)
 	}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/migration"
+	"github.com/swagftw/stripe_pay_service/utl/storage"
+)
+
+// migrate runs a migrate subcommand and returns the exit code.
+func migrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+
+		return 2
+	}
+
+	ctx := context.Background()
+
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		logger.Logger.Error(ctx, "error connecting to the database", err)
+
+		return 1
+	}
+
+	defer func() { _ = storage.Close(db) }()
+
+	migrator, err := migration.NewMigrator(db)
+	if err != nil {
+		logger.Logger.Error(ctx, "error loading migrations", err)
+
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		printMigrations("applied", applied)
+
+		if err != nil {
+			logger.Logger.Error(ctx, "error applying migrations", err)
+
+			return 1
+		}
+	case "down":
+		flags := flag.NewFlagSet("down", flag.ExitOnError)
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		_ = flags.Parse(args[1:])
+
+		reverted, err := migrator.Down(ctx, *steps)
+		printMigrations("reverted", reverted)
+
+		if err != nil {
+			logger.Logger.Error(ctx, "error reverting migrations", err)
+
+			return 1
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			logger.Logger.Error(ctx, "error reading migration status", err)
+
+			return 1
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+
+		_ = w.Flush()
+	case "redact-payloads":
+		return redactPayloads(ctx, db, args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+
+		return 2
+	}
+
+	return 0
+}
+
+// createMigration writes the files of a new migration to the source tree, they are embedded on the next build.
+func createMigration(args []string) int {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	dir := flags.String("dir", migration.Dir, "directory of the migration files")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: api migrate create [-dir dir] <name>")
+
+		return 2
+	}
+
+	up, down, err := migration.Create(*dir, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return 1
+	}
+
+	fmt.Printf("created %s\ncreated %s\n", up, down)
+
+	return 0
+}
+
+func printMigrations(action string, migrations []migration.Migration) {
+	for _, m := range migrations {
+		fmt.Printf("%s %04d_%s\n", action, m.Version, m.Name)
+	}
+
+	if len(migrations) == 0 {
+		fmt.Printf("nothing %s\n", action)
+	}
+}
+
+// redactPayloads masks the secrets and PII of the payloads stored before redaction was in place.
+func redactPayloads(ctx context.Context, db *gorm.DB, args []string) int {
+	flags := flag.NewFlagSet("redact-payloads", flag.ExitOnError)
+	batchSize := flags.Int("batch", 500, "payment intents read at a time")
+	dryRun := flags.Bool("dry-run", false, "count the payloads to redact without writing them")
+	_ = flags.Parse(args)
+
+	if *batchSize <= 0 {
+		fmt.Fprintln(os.Stderr, "-batch must be positive")
+
+		return 2
+	}
+
+	redacted, err := migration.RedactPayloads(ctx, db, *batchSize, *dryRun)
+	if err != nil {
+		logger.Logger.Error(ctx, "error redacting payloads", err, redacted)
+
+		return 1
+	}
+
+	if *dryRun {
+		fmt.Printf("payloads to redact: %d\n", redacted)
+
+		return 0
+	}
+
+	fmt.Printf("payloads redacted: %d\n", redacted)
+
+	return 0
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/swagftw/stripe_pay_service/pkg/auth"
+	"github.com/swagftw/stripe_pay_service/pkg/auth/repository/postgres"
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/bootstrap"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/storage"
+)
+
+// issues a new api key and prints it, the key can not be recovered afterwards.
+func main() {
+	cfgPath := flag.String("config", "./utl/config/config.local.yaml", "config file")
+	clientID := flag.String("client", "", "client or merchant id the key belongs to")
+	name := flag.String("name", "", "human readable name of the key")
+	scopes := flag.String("scopes", strings.Join([]string{types.ScopePaymentsRead, types.ScopePaymentsWrite, types.ScopePaymentsCapture, types.ScopeRefundsWrite}, ","), "comma separated scopes")
+	flag.Parse()
+
+	if *clientID == "" {
+		fmt.Fprintln(os.Stderr, "-client is required")
+		os.Exit(2)
+	}
+
+	// load the config, configure the logger and fetch the secrets
+	err := bootstrap.Init(context.Background(), *cfgPath)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		os.Exit(1)
+	}
+
+	authService := auth.NewService(postgres.NewAPIKeyRepo(db))
+
+	rawKey, key, err := authService.CreateAPIKey(context.Background(), *clientID, *name, strings.Split(*scopes, ","))
+	if err != nil {
+		logger.Logger.Error(context.Background(), "error creating api key", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("id: %s\nclient: %s\nscopes: %s\nkey: %s\n", key.ID, key.ClientID, key.Scopes, rawKey)
+}
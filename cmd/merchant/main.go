@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/swagftw/stripe_pay_service/pkg/merchants"
+	"github.com/swagftw/stripe_pay_service/pkg/merchants/repository/postgres"
+	"github.com/swagftw/stripe_pay_service/utl/bootstrap"
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/encrypt"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/storage"
+	"github.com/swagftw/stripe_pay_service/utl/stripeclient"
+)
+
+// onboards a merchant with its own stripe account, api keys are then issued for the printed merchant id.
+func main() {
+	cfgPath := flag.String("config", "./utl/config/config.local.yaml", "config file")
+	name := flag.String("name", "", "merchant (brand) name")
+	secretKey := flag.String("secret-key", "", "stripe secret key of the merchant account")
+	publishableKey := flag.String("publishable-key", "", "stripe publishable key of the merchant account")
+	flag.Parse()
+
+	if *name == "" || *secretKey == "" {
+		fmt.Fprintln(os.Stderr, "-name and -secret-key are required")
+		os.Exit(2)
+	}
+
+	// load the config, configure the logger and fetch the secrets
+	err := bootstrap.Init(context.Background(), *cfgPath)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	// keys of the wrong mode would move real money from dev or fake it in prod
+	for _, key := range []string{*secretKey, *publishableKey} {
+		if key == "" {
+			continue
+		}
+
+		err = stripeclient.CheckMode(config.GetGlobalConfig().GetServerConfig().Environment, key)
+		if err != nil {
+			logger.Logger.Error(context.Background(), "refusing to onboard merchant", err)
+			os.Exit(1)
+		}
+	}
+
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		os.Exit(1)
+	}
+
+	cipher, err := encrypt.New(config.GetGlobalConfig().GetSecurityConfig().EncryptionKey)
+	if err != nil {
+		logger.Logger.Error(context.Background(), "error creating cipher from encryption key", err)
+		os.Exit(1)
+	}
+
+	merchantService := merchants.NewService(postgres.NewMerchantsRepo(db), cipher)
+
+	merchant, err := merchantService.CreateMerchant(context.Background(), *name, *secretKey, *publishableKey)
+	if err != nil {
+		logger.Logger.Error(context.Background(), "error creating merchant", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("id: %s\nname: %s\n", merchant.ID, merchant.Name)
+}
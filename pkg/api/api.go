@@ -1,22 +1,133 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/pkg/auth"
+	authPostgres "github.com/swagftw/stripe_pay_service/pkg/auth/repository/postgres"
+	"github.com/swagftw/stripe_pay_service/pkg/merchants"
+	merchantsPostgres "github.com/swagftw/stripe_pay_service/pkg/merchants/repository/postgres"
 	"github.com/swagftw/stripe_pay_service/pkg/payments"
 	postgres2 "github.com/swagftw/stripe_pay_service/pkg/payments/repository/postgres"
 	"github.com/swagftw/stripe_pay_service/transaction/postgres"
+	adminHTTP "github.com/swagftw/stripe_pay_service/transport/admin"
+	docsHTTP "github.com/swagftw/stripe_pay_service/transport/docs"
+	paymentsGRPC "github.com/swagftw/stripe_pay_service/transport/grpc"
+	healthHTTP "github.com/swagftw/stripe_pay_service/transport/health"
 	paymentsHTTP "github.com/swagftw/stripe_pay_service/transport/payments"
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/encrypt"
+	"github.com/swagftw/stripe_pay_service/utl/health"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/migration"
+	"github.com/swagftw/stripe_pay_service/utl/ratelimit"
+	"github.com/swagftw/stripe_pay_service/utl/secrets"
 	"github.com/swagftw/stripe_pay_service/utl/server"
 	"github.com/swagftw/stripe_pay_service/utl/storage"
 	"github.com/swagftw/stripe_pay_service/utl/stripeclient"
+	"github.com/swagftw/stripe_pay_service/utl/tracing"
 )
 
-func Start() {
-	echoServer := server.NewServer()
+// Start builds the services and serves them until the server is shut down,
+// the database pool is closed and traces are flushed once in-flight requests drained.
+// the error is returned when the service can not start or did not stop cleanly, it was logged already.
+func Start() error {
+	// traces are flushed once the server stopped
+	shutdownTracing, err := tracing.Init(context.Background(), config.GetGlobalConfig().GetTracingConfig())
+	if err != nil {
+		logger.Logger.Error(context.TODO(), "error initializing tracing", err)
+
+		return err
+	}
+
+	defer func() {
+		err := shutdownTracing(context.Background())
+		if err != nil {
+			logger.Logger.Error(context.TODO(), "error flushing traces", err)
+		}
+	}()
+
+	// live keys must not reach dev and staging, nor test keys prod
+	err = checkStripeMode(config.GetGlobalConfig())
+	if err != nil {
+		logger.Logger.Error(context.TODO(), "refusing to start with stripe keys of the wrong mode", err)
+
+		return err
+	}
+
+	// merchant stripe keys are stored encrypted, without a key the service must not start
+	cipher, err := encrypt.New(config.GetGlobalConfig().GetSecurityConfig().EncryptionKey)
+	if err != nil {
+		logger.Logger.Error(context.TODO(), "error creating cipher from encryption key", err)
+
+		return err
+	}
+
+	// the log level and rate limits follow changes of the config files until the server stopped
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+
+	err = config.Watch(watchCtx)
+	if err != nil {
+		logger.Logger.Error(context.TODO(), "error watching config files, changes need a restart", err)
+	}
+
+	// rotated secrets are fetched again while serving
+	secrets.Watch(watchCtx)
+
+	echoServer, err := server.NewServer()
+	if err != nil {
+		return err
+	}
 
 	// get new postgres database connection
 	db, err := storage.NewPostgresDB()
 	if err != nil {
-		return
+		logger.Logger.Error(context.TODO(), "error connecting to the database", err)
+
+		return err
+	}
+
+	// the pool is closed once in-flight requests drained, before traces are flushed
+	defer func() {
+		err := storage.Close(db)
+		if err != nil {
+			logger.Logger.Error(context.TODO(), "error closing database connections", err)
+		}
+	}()
+
+	// migrations are only applied on start when configured, otherwise with `api migrate up`
+	if config.GetGlobalConfig().GetDBConfig().AutoMigrate {
+		migrator, err := migration.NewMigrator(db)
+		if err != nil {
+			logger.Logger.Error(context.TODO(), "error loading migrations", err)
+
+			return err
+		}
+
+		_, err = migrator.Up(context.Background())
+		if err != nil {
+			logger.Logger.Error(context.TODO(), "error applying migrations", err)
+
+			return err
+		}
+	}
+
+	// rate limit buckets live in memory unless instances share them through postgres
+	rateLimitCfg := config.GetGlobalConfig().GetRateLimitConfig()
+
+	rateLimitStore, err := newRateLimitStore(rateLimitCfg.Store, db)
+	if err != nil {
+		logger.Logger.Error(context.TODO(), "error creating rate limit store", err)
+
+		return err
 	}
 
 	// init postgres transaction
@@ -25,11 +136,144 @@ func Start() {
 	v1Group := echoServer.Group("/api/v1")
 
 	// initialize services
-	// init payments service
-	payService := payments.NewService(postgresTx, postgres2.NewPaymentsRepo(db), stripeclient.New())
+	// init auth service
+	authService := auth.NewService(authPostgres.NewAPIKeyRepo(db))
+
+	// init merchants service
+	merchantService := merchants.NewService(merchantsPostgres.NewMerchantsRepo(db), cipher)
+
+	// init payments service, stripe is called with the account of the authenticated merchant
+	payService := payments.NewService(postgresTx, postgres2.NewPaymentsRepo(db), stripeclient.NewForMerchants(merchantService))
+
+	// the http routes and grpc methods share the rate limit buckets
+	limiter := server.NewRateLimiter(rateLimitCfg, rateLimitStore)
 
 	// init http handlers
-	paymentsHTTP.InitHTTPHandlers(payService, v1Group)
+	paymentsHTTP.InitHTTPHandlers(payService, v1Group, server.APIKeyAuth(authService), limiter)
+	adminHTTP.InitHTTPHandlers(v1Group, server.APIKeyAuth(authService))
+	healthHTTP.InitHTTPHandlers(echoServer, newHealthChecker(config.GetGlobalConfig().GetHealthConfig(), db))
+
+	err = docsHTTP.InitHTTPHandlers(v1Group, paymentsHTTP.OpenAPI(), config.GetGlobalConfig().GetServerConfig().DocsUI)
+	if err != nil {
+		logger.Logger.Error(context.TODO(), "error rendering openapi document", err)
+
+		return err
+	}
+
+	// the grpc transport serves the same services and is stopped once the http server drained
+	if grpcCfg := config.GetGlobalConfig().GetGRPCConfig(); grpcCfg.Enabled {
+		stopGRPC, err := startGRPC(grpcCfg, payService, authService, limiter)
+		if err != nil {
+			logger.Logger.Error(context.TODO(), "error starting grpc server", err)
+
+			return err
+		}
+
+		defer stopGRPC()
+	}
+
+	return server.StartServer(echoServer)
+}
+
+// startGRPC serves the payment service over grpc on the configured port and returns a func stopping it,
+// in-flight calls are drained for the shutdown timeout of the http server before they are cancelled.
+func startGRPC(cfg *config.GRPC, payService types.PaymentService, authService types.AuthService, limiter *server.RateLimiter) (func(), error) {
+	var verifier *server.JWTVerifier
+
+	if jwtCfg := config.GetGlobalConfig().GetJWTConfig(); jwtCfg.Enabled {
+		var err error
+
+		verifier, err = server.NewJWTVerifier(jwtCfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("tcp", ":"+cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	grpcServer := paymentsGRPC.NewServer(payService, authService, verifier, limiter)
+
+	go func() {
+		err := grpcServer.Serve(listener)
+		if err != nil {
+			logger.Logger.Error(context.TODO(), "error serving grpc", err)
+		}
+	}()
+
+	shutdownTimeout := time.Duration(config.GetGlobalConfig().GetServerConfig().ShutdownTimeout) * time.Second
+
+	return func() {
+		stopped := make(chan struct{})
+
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-time.After(shutdownTimeout):
+			grpcServer.Stop()
+		}
+	}, nil
+}
+
+// checkStripeMode checks that the configured stripe keys are of the mode the environment must use,
+// merchant keys are checked when they are onboarded and when they are used.
+func checkStripeMode(cfg *config.GlobalConfig) error {
+	environment := cfg.GetServerConfig().Environment
+
+	for _, key := range []string{cfg.GetStripeSecretKey(), cfg.GetStripeConfig().PublishableKey} {
+		if key == "" {
+			continue
+		}
+
+		err := stripeclient.CheckMode(environment, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newHealthChecker returns the readiness checks of the database, the applied migrations and stripe,
+// stripe is checked at most once per cache ttl.
+func newHealthChecker(cfg *config.Health, db *gorm.DB) *health.Checker {
+	checker := health.NewChecker(time.Duration(cfg.Timeout) * time.Second)
+
+	environment := config.GetGlobalConfig().GetServerConfig().Environment
+	checker.SetInfo("environment", environment)
+
+	// the mode of the configured key, keys rotated to another mode are refused so it holds while running
+	stripeMode, err := stripeclient.Mode(config.GetGlobalConfig().GetStripeSecretKey())
+	if err != nil {
+		stripeMode = "unknown"
+	}
+
+	checker.SetInfo("stripeMode", stripeMode)
+
+	checker.Register("database", health.Database(db))
+	checker.Register("migrations", func(ctx context.Context) error {
+		return migration.Applied(ctx, db)
+	})
+	checker.Register("stripe", health.Cached(func(ctx context.Context) error {
+		return stripeclient.Ping(ctx, stripe.APIURL)
+	}, time.Duration(cfg.StripeCacheTTL)*time.Second))
+
+	return checker
+}
+
+func newRateLimitStore(kind string, db *gorm.DB) (ratelimit.Store, error) {
+	switch kind {
+	case "", "memory":
+		return ratelimit.NewMemoryStore(), nil
+	case "postgres":
+		return ratelimit.NewPostgresStore(db), nil
+	}
 
-	server.StartServer(echoServer)
+	return nil, fmt.Errorf("unknown rate limit store %q", kind)
 }
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+)
+
+// KeyPrefix starts every api key issued by the service, it lets the auth middleware tell api keys from other tokens.
+const KeyPrefix = "psk_"
+
+const (
+	lookupLength = 8
+	secretLength = 32
+	alphabet     = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+var errInvalidAPIKey = errors.New("invalid api key")
+
+type service struct {
+	repo Repository
+}
+
+// Authenticate resolves the principal for the given raw api key.
+func (s service) Authenticate(ctx context.Context, apiKey string) (*types.Principal, error) {
+	prefix, ok := parseKey(apiKey)
+	if !ok {
+		return nil, unauthorized(errInvalidAPIKey)
+	}
+
+	key, err := s.repo.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.RevokedAt != nil {
+		return nil, unauthorized(errors.New("api key revoked"))
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashKey(apiKey)), []byte(key.Hash)) != 1 {
+		return nil, unauthorized(errInvalidAPIKey)
+	}
+
+	// api keys are issued per merchant, so the client is the merchant itself
+	return &types.Principal{
+		ClientID:   key.ClientID,
+		MerchantID: key.ClientID,
+		KeyID:      key.ID,
+		Scopes:     key.ScopeList(),
+	}, nil
+}
+
+// CreateAPIKey issues a new api key for the client.
+// the raw key is returned only once, the service keeps nothing but its hash.
+func (s service) CreateAPIKey(ctx context.Context, clientID, name string, scopes []string) (string, *APIKey, error) {
+	lookup, err := randomString(lookupLength)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := randomString(secretLength)
+	if err != nil {
+		return "", nil, err
+	}
+
+	raw := KeyPrefix + lookup + "_" + secret
+
+	key := &APIKey{
+		ClientID: clientID,
+		Name:     name,
+		Prefix:   lookup,
+		Hash:     hashKey(raw),
+		Scopes:   strings.Join(scopes, ","),
+	}
+
+	err = s.repo.CreateAPIKey(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return raw, key, nil
+}
+
+// parseKey returns the lookup prefix of a key in the psk_<prefix>_<secret> format.
+func parseKey(apiKey string) (string, bool) {
+	if !strings.HasPrefix(apiKey, KeyPrefix) {
+		return "", false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(apiKey, KeyPrefix), "_")
+	if len(parts) != 2 || len(parts[0]) != lookupLength || len(parts[1]) != secretLength {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+func hashKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(size int) (string, error) {
+	out := make([]byte, size)
+	max := big.NewInt(int64(len(alphabet)))
+
+	for i := range out {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+
+		out[i] = alphabet[n.Int64()]
+	}
+
+	return string(out), nil
+}
+
+func unauthorized(err error) error {
+	return fault.ErrUnauthorized.New("auth", "provide a valid api key in the Authorization header", err)
+}
+
+// NewService creates a new auth service.
+func NewService(repo Repository) Service {
+	return &service{
+		repo: repo,
+	}
+}
@@ -0,0 +1,175 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/stripe_pay_service/pkg/auth"
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/mock"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+// newKeyStore returns a repository keeping keys in memory.
+func newKeyStore() mock.APIKeyMockRepository {
+	keys := make(map[string]*auth.APIKey)
+
+	return mock.APIKeyMockRepository{
+		CreateAPIKeyFn: func(ctx context.Context, key *auth.APIKey) error {
+			key.ID = "ak_" + key.Prefix
+			keys[key.Prefix] = key
+
+			return nil
+		},
+		GetAPIKeyByPrefixFn: func(ctx context.Context, prefix string) (*auth.APIKey, error) {
+			key, ok := keys[prefix]
+			if !ok {
+				return nil, errors.New("api key not found")
+			}
+
+			return key, nil
+		},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	authService := auth.NewService(newKeyStore())
+
+	rawKey, _, err := authService.CreateAPIKey(context.TODO(), "merchant_1", "test", []string{types.ScopePaymentsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revokedKey, revoked, err := authService.CreateAPIKey(context.TODO(), "merchant_1", "revoked", []string{types.ScopePaymentsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	revoked.RevokedAt = &now
+
+	cases := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{
+			name:    "success",
+			key:     rawKey,
+			wantErr: false,
+		},
+		{
+			name:    "wrong secret",
+			key:     rawKey[:len(rawKey)-1] + "x",
+			wantErr: true,
+		},
+		{
+			name:    "malformed key",
+			key:     "sk_test_123",
+			wantErr: true,
+		},
+		{
+			name:    "revoked key",
+			key:     revokedKey,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := authService.Authenticate(context.TODO(), tt.key)
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if !tt.wantErr {
+				assert.Equal(t, "merchant_1", principal.ClientID)
+				assert.True(t, principal.HasScope(types.ScopePaymentsRead))
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthMiddleware(t *testing.T) {
+	logger.InitLogger()
+
+	authService := auth.NewService(newKeyStore())
+
+	readKey, _, err := authService.CreateAPIKey(context.TODO(), "merchant_1", "read", []string{types.ScopePaymentsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.HTTPErrorHandler = server.ErrorHandler
+
+	g := e.Group("/payments", server.APIKeyAuth(authService))
+	g.GET("/get_intents", func(c echo.Context) error {
+		principal, _ := types.PrincipalFromContext(server.ToGoContext(c))
+
+		return c.String(http.StatusOK, principal.ClientID)
+	}, server.RequireScope(types.ScopePaymentsRead))
+	g.POST("/create_refund/:id", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	}, server.RequireScope(types.ScopeRefundsWrite))
+
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		header     string
+		wantStatus int
+	}{
+		{
+			name:       "success",
+			method:     http.MethodGet,
+			path:       "/payments/get_intents",
+			header:     "Bearer " + readKey,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing header",
+			method:     http.MethodGet,
+			path:       "/payments/get_intents",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid key",
+			method:     http.MethodGet,
+			path:       "/payments/get_intents",
+			header:     "Bearer psk_invalid",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "missing scope",
+			method:     http.MethodPost,
+			path:       "/payments/create_refund/pi_123",
+			header:     "Bearer " + readKey,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, "merchant_1", rec.Body.String())
+			}
+		})
+	}
+}
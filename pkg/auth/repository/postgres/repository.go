@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/pkg/auth"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/storage"
+)
+
+type repository struct {
+	db *gorm.DB
+}
+
+// CreateAPIKey stores a new api key.
+func (r repository) CreateAPIKey(ctx context.Context, key *auth.APIKey) error {
+	db := storage.GetGormDBFromContext(ctx, r.db)
+
+	return db.Model(key).Create(key).Error
+}
+
+// GetAPIKeyByPrefix gets the api key with the given lookup prefix, from the primary so that revoked and new keys
+// take effect at once.
+func (r repository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*auth.APIKey, error) {
+	db := storage.GetGormDBFromContext(ctx, r.db)
+
+	key := new(auth.APIKey)
+	err := db.Where("prefix = ?", prefix).First(key).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, fault.ErrUnauthorized.New("auth_repo", "provide a valid api key in the Authorization header", err)
+	}
+
+	return key, err
+}
+
+// NewAPIKeyRepo returns a new api key repository.
+func NewAPIKeyRepo(db *gorm.DB) auth.Repository {
+	return &repository{
+		db: db,
+	}
+}
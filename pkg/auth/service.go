@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/storage"
+)
+
+type (
+	// Service is the api key service, it authenticates api clients and issues new keys.
+	Service interface {
+		types.AuthService
+		CreateAPIKey(ctx context.Context, clientID, name string, scopes []string) (string, *APIKey, error)
+	}
+
+	// Repository is the interface for the api key repository.
+	Repository interface {
+		CreateAPIKey(ctx context.Context, key *APIKey) error
+		GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	}
+
+	// APIKey is the db model for an api key.
+	// only the sha256 hash of the key is stored, the prefix is kept in clear to look the key up.
+	APIKey struct {
+		ID        string `gorm:"primaryKey;default:('ak_' || generate_uid(12));not null"`
+		ClientID  string `gorm:"not null;index"`
+		Name      string
+		Prefix    string `gorm:"not null;uniqueIndex"`
+		Hash      string `gorm:"not null"`
+		Scopes    string `gorm:"not null"`
+		RevokedAt *time.Time
+		storage.GormBase
+	}
+)
+
+func (*APIKey) TableName() string {
+	return "payment.api_keys"
+}
+
+// ScopeList returns the scopes granted to the key.
+func (k *APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return nil
+	}
+
+	return strings.Split(k.Scopes, ",")
+}
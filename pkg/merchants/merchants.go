@@ -0,0 +1,57 @@
+package merchants
+
+import (
+	"context"
+
+	"github.com/swagftw/stripe_pay_service/utl/encrypt"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+)
+
+type service struct {
+	repo   Repository
+	cipher *encrypt.Cipher
+}
+
+// CreateMerchant stores a new merchant, its stripe secret key is encrypted before it reaches the db.
+func (s service) CreateMerchant(ctx context.Context, name, secretKey, publishableKey string) (*Merchant, error) {
+	encrypted, err := s.cipher.Encrypt(secretKey)
+	if err != nil {
+		return nil, fault.ErrInternal.New("merchants", "something went wrong", err)
+	}
+
+	merchant := &Merchant{
+		Name:                 name,
+		StripeSecretKey:      encrypted,
+		StripePublishableKey: publishableKey,
+	}
+
+	err = s.repo.CreateMerchant(ctx, merchant)
+	if err != nil {
+		return nil, err
+	}
+
+	return merchant, nil
+}
+
+// StripeSecretKey returns the decrypted stripe secret key of the merchant.
+func (s service) StripeSecretKey(ctx context.Context, merchantID string) (string, error) {
+	merchant, err := s.repo.GetMerchant(ctx, merchantID)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := s.cipher.Decrypt(merchant.StripeSecretKey)
+	if err != nil {
+		return "", fault.ErrInternal.New("merchants", "something went wrong", err)
+	}
+
+	return key, nil
+}
+
+// NewService creates a new merchants service.
+func NewService(repo Repository, cipher *encrypt.Cipher) Service {
+	return &service{
+		repo:   repo,
+		cipher: cipher,
+	}
+}
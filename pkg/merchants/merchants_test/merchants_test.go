@@ -0,0 +1,87 @@
+package merchants_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/stripe_pay_service/pkg/merchants"
+	"github.com/swagftw/stripe_pay_service/utl/encrypt"
+	"github.com/swagftw/stripe_pay_service/utl/mock"
+)
+
+const encryptionKey = "hxvQgZqPeGJtN6x9OzkzlK94xTmvlkpWk5SaVICOKfA="
+
+func TestStripeSecretKey(t *testing.T) {
+	cipher, err := encrypt.New(encryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := make(map[string]*merchants.Merchant)
+
+	repo := mock.MerchantMockRepository{
+		CreateMerchantFn: func(ctx context.Context, merchant *merchants.Merchant) error {
+			merchant.ID = "mr_" + merchant.Name
+			stored[merchant.ID] = merchant
+
+			return nil
+		},
+		GetMerchantFn: func(ctx context.Context, id string) (*merchants.Merchant, error) {
+			merchant, ok := stored[id]
+			if !ok {
+				return nil, errors.New("merchant not found")
+			}
+
+			return merchant, nil
+		},
+	}
+
+	merchantService := merchants.NewService(repo, cipher)
+
+	merchant, err := merchantService.CreateMerchant(context.TODO(), "brand", "sk_test_brand", "pk_test_brand")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key must never reach the repository in clear
+	assert.False(t, strings.Contains(stored[merchant.ID].StripeSecretKey, "sk_test_brand"))
+
+	cases := []struct {
+		name       string
+		merchantID string
+		wantKey    string
+		wantErr    bool
+	}{
+		{
+			name:       "success",
+			merchantID: merchant.ID,
+			wantKey:    "sk_test_brand",
+		},
+		{
+			name:       "unknown merchant",
+			merchantID: "mr_unknown",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := merchantService.StripeSecretKey(context.TODO(), tt.merchantID)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantKey, key)
+		})
+	}
+
+	// a different key can not decrypt what was stored
+	otherCipher, err := encrypt.New("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = merchants.NewService(repo, otherCipher).StripeSecretKey(context.TODO(), merchant.ID)
+	assert.NotNil(t, err)
+}
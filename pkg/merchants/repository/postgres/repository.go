@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/pkg/merchants"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/storage"
+)
+
+type repository struct {
+	db *gorm.DB
+}
+
+// CreateMerchant creates a merchant.
+func (r repository) CreateMerchant(ctx context.Context, merchant *merchants.Merchant) error {
+	db := storage.GetGormDBFromContext(ctx, r.db)
+
+	return db.Model(merchant).Create(merchant).Error
+}
+
+// GetMerchant gets the merchant, from the primary so that new merchants and rotated keys are seen at once.
+func (r repository) GetMerchant(ctx context.Context, id string) (*merchants.Merchant, error) {
+	db := storage.GetGormDBFromContext(ctx, r.db)
+
+	merchant := new(merchants.Merchant)
+	err := db.Where("id = ?", id).First(merchant).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, fault.ErrUnknownMerchant.New("merchant_repo", "unknown merchant", err)
+	}
+
+	return merchant, err
+}
+
+// NewMerchantsRepo returns a new merchants repository.
+func NewMerchantsRepo(db *gorm.DB) merchants.Repository {
+	return &repository{
+		db: db,
+	}
+}
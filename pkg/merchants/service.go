@@ -0,0 +1,35 @@
+package merchants
+
+import (
+	"context"
+
+	"github.com/swagftw/stripe_pay_service/utl/storage"
+)
+
+type (
+	// Service is the merchants service, it onboards merchants and resolves their stripe credentials.
+	Service interface {
+		CreateMerchant(ctx context.Context, name, secretKey, publishableKey string) (*Merchant, error)
+		StripeSecretKey(ctx context.Context, merchantID string) (string, error)
+	}
+
+	// Repository is the interface for the merchants repository.
+	Repository interface {
+		CreateMerchant(ctx context.Context, merchant *Merchant) error
+		GetMerchant(ctx context.Context, id string) (*Merchant, error)
+	}
+
+	// Merchant is the db model for a merchant (brand) with its own stripe account.
+	Merchant struct {
+		ID   string `gorm:"primaryKey;default:('mr_' || generate_uid(12));not null"`
+		Name string `gorm:"not null"`
+		// StripeSecretKey is stored encrypted with the configured encryption key.
+		StripeSecretKey      string `gorm:"not null"`
+		StripePublishableKey string
+		storage.GormBase
+	}
+)
+
+func (*Merchant) TableName() string {
+	return "payment.merchants"
+}
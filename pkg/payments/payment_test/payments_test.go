@@ -2,6 +2,7 @@ package payment_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -10,15 +11,19 @@ import (
 	"github.com/swagftw/stripe_pay_service/transaction"
 	"github.com/swagftw/stripe_pay_service/types"
 	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
 	"github.com/swagftw/stripe_pay_service/utl/logger"
 	"github.com/swagftw/stripe_pay_service/utl/mock"
 	"github.com/swagftw/stripe_pay_service/utl/stripeclient"
 )
 
+// encryptionKeyOverride sets the encryption key the config requires, .env leaves it empty.
+const encryptionKeyOverride = "security.encryptionKey=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
 func TestCreatePaymentIntent(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../../utl/config/config.local.yaml", "../../../.env")
+	err := config.InitConfig("../../../utl/config/config.local.yaml", "../../../.env", encryptionKeyOverride)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -75,7 +80,7 @@ func TestCreatePaymentIntent(t *testing.T) {
 func TestCapturePaymentIntent(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../../utl/config/config.local.yaml", "../../../.env")
+	err := config.InitConfig("../../../utl/config/config.local.yaml", "../../../.env", encryptionKeyOverride)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -95,16 +100,31 @@ func TestCapturePaymentIntent(t *testing.T) {
 			stripeService: stripeclient.NewMock(),
 			tx:            mock.NewTxMock(),
 			repo: mock.PaymentMockRepository{
-				GetPaymentFn: func(ctx context.Context, id string) (*payments.PaymentIntent, error) {
+				GetPaymentForUpdateFn: func(ctx context.Context, id string) (*payments.PaymentIntent, error) {
 					return &payments.PaymentIntent{
-						ID: "",
+						ID:     "",
+						Status: payments.StatusRequiresPaymentMethod,
 					}, nil
 				},
-				UpdatePaymentFn: func(ctx context.Context, payment *payments.PaymentIntent) error {
+				TransitionPaymentFn: func(ctx context.Context, payment *payments.PaymentIntent, next payments.Status, actor, reason string) error {
 					return nil
 				},
 			},
 		},
+		{
+			name:          "canceled intent",
+			id:            "123",
+			wantErr:       true,
+			stripeService: stripeclient.NewMock(),
+			tx:            mock.NewTxMock(),
+			repo: mock.PaymentMockRepository{
+				GetPaymentForUpdateFn: func(ctx context.Context, id string) (*payments.PaymentIntent, error) {
+					return &payments.PaymentIntent{
+						Status: payments.StatusCanceled,
+					}, nil
+				},
+			},
+		},
 	}
 
 	for _, tt := range cases {
@@ -116,10 +136,48 @@ func TestCapturePaymentIntent(t *testing.T) {
 	}
 }
 
+func TestCapturePaymentIntentRejectedStatus(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   payments.Status
+		wantKind *fault.Kind
+	}{
+		{name: "requires action", status: payments.StatusRequiresAction, wantKind: fault.ErrUnexpectedState},
+		{name: "processing", status: payments.StatusProcessing, wantKind: fault.ErrUnexpectedState},
+		{name: "succeeded", status: payments.StatusSucceeded, wantKind: fault.ErrAlreadyCaptured},
+		{name: "canceled", status: payments.StatusCanceled, wantKind: fault.ErrIllegalTransition},
+		{name: "refunded", status: payments.StatusRefunded, wantKind: fault.ErrIllegalTransition},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			stripeCalls := 0
+
+			repo := mock.PaymentMockRepository{
+				GetPaymentForUpdateFn: func(ctx context.Context, id string) (*payments.PaymentIntent, error) {
+					return &payments.PaymentIntent{ProviderID: id, Amount: 5000, Status: tt.status}, nil
+				},
+			}
+			stripeService := mock.StripeMockService{
+				CapturePaymentIntentFn: func(ctx context.Context, paymentID string, amount int) (*types.CaptureIntentRes, error) {
+					stripeCalls++
+
+					return nil, errors.New("stripe must not be called")
+				},
+			}
+
+			payS := payments.NewService(mock.NewTxMock(), repo, stripeService)
+			_, err := payS.CapturePaymentIntent(context.TODO(), "pi_1")
+			assert.True(t, errors.Is(err, tt.wantKind))
+			assert.Equal(t, 0, stripeCalls)
+		})
+	}
+}
+
 func TestGetPaymentIntents(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../../utl/config/config.local.yaml", "../../../.env")
+	err := config.InitConfig("../../../utl/config/config.local.yaml", "../../../.env", encryptionKeyOverride)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -145,10 +203,60 @@ func TestGetPaymentIntents(t *testing.T) {
 	}
 }
 
+func TestGetPaymentIntent(t *testing.T) {
+	logger.InitLogger()
+
+	stored := &payments.PaymentIntent{
+		ProviderID: "pi_1",
+		Amount:     5000,
+		Status:     payments.StatusSucceeded,
+		Payload:    `{"id":"pi_1","amount":5000,"currency":"inr","status":"requires_payment_method","receipt_email":"jane@example.com"}`,
+	}
+
+	cases := []struct {
+		name       string
+		id         string
+		wantErr    bool
+		wantStatus string
+	}{
+		{name: "stored intent in its current status", id: "pi_1", wantStatus: string(payments.StatusSucceeded)},
+		{name: "unknown intent", id: "pi_2", wantErr: true},
+	}
+
+	repo := mock.PaymentMockRepository{
+		GetPaymentFn: func(ctx context.Context, id string) (*payments.PaymentIntent, error) {
+			if id != stored.ProviderID {
+				return nil, fault.ErrPaymentNotFound.New("payment_repo", "provide valid intent id", errors.New("record not found"))
+			}
+
+			return stored, nil
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			payS := payments.NewService(mock.NewTxMock(), repo, stripeclient.NewMock())
+
+			intent, err := payS.GetPaymentIntent(context.TODO(), tt.id)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, fault.ErrPaymentNotFound))
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, "pi_1", intent.ID)
+			assert.Equal(t, 5000, intent.Amount)
+			assert.Equal(t, "inr", intent.Currency)
+			assert.Equal(t, tt.wantStatus, intent.Status)
+		})
+	}
+}
+
 func TestCreateRefund(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../../utl/config/config.local.yaml", "../../../.env")
+	err := config.InitConfig("../../../utl/config/config.local.yaml", "../../../.env", encryptionKeyOverride)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -168,14 +276,29 @@ func TestCreateRefund(t *testing.T) {
 			stripeService: stripeclient.NewMock(),
 			tx:            mock.NewTxMock(),
 			repo: mock.PaymentMockRepository{
-				UpdatePaymentFn: func(ctx context.Context, payment *payments.PaymentIntent) error {
+				TransitionPaymentFn: func(ctx context.Context, payment *payments.PaymentIntent, next payments.Status, actor, reason string) error {
 					return nil
 				},
 				CreateRefundFn: func(ctx context.Context, refund *payments.Refund) (*payments.Refund, error) {
 					return &payments.Refund{}, nil
 				},
-				GetPaymentFn: func(ctx context.Context, id string) (*payments.PaymentIntent, error) {
-					return &payments.PaymentIntent{}, nil
+				GetPaymentForUpdateFn: func(ctx context.Context, id string) (*payments.PaymentIntent, error) {
+					return &payments.PaymentIntent{Status: payments.StatusSucceeded}, nil
+				},
+				GetRefundsFn: func(ctx context.Context, paymentIntentID string) ([]*payments.Refund, error) {
+					return nil, nil
+				},
+			},
+		},
+		{
+			name:          "uncaptured intent",
+			paymentID:     "123",
+			wantErr:       true,
+			stripeService: stripeclient.NewMock(),
+			tx:            mock.NewTxMock(),
+			repo: mock.PaymentMockRepository{
+				GetPaymentForUpdateFn: func(ctx context.Context, id string) (*payments.PaymentIntent, error) {
+					return &payments.PaymentIntent{Status: payments.StatusRequiresCapture}, nil
 				},
 			},
 		},
@@ -189,3 +312,56 @@ func TestCreateRefund(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateRefundExistingRefunds(t *testing.T) {
+	cases := []struct {
+		name            string
+		refundStatus    string
+		wantKind        *fault.Kind
+		wantStripeCalls int
+	}{
+		{name: "pending refund", refundStatus: "pending", wantKind: fault.ErrRefundPending},
+		{name: "refund requiring action", refundStatus: "requires_action", wantKind: fault.ErrRefundPending},
+		{name: "succeeded refund", refundStatus: "succeeded", wantKind: fault.ErrAlreadyRefunded},
+		{name: "failed refund", refundStatus: "failed", wantStripeCalls: 1},
+		{name: "canceled refund", refundStatus: "canceled", wantStripeCalls: 1},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			stripeCalls := 0
+
+			repo := mock.PaymentMockRepository{
+				GetPaymentForUpdateFn: func(ctx context.Context, id string) (*payments.PaymentIntent, error) {
+					return &payments.PaymentIntent{ProviderID: id, Amount: 5000, Status: payments.StatusSucceeded}, nil
+				},
+				GetRefundsFn: func(ctx context.Context, paymentIntentID string) ([]*payments.Refund, error) {
+					providerID := "re_1"
+
+					return []*payments.Refund{{ProviderID: &providerID, PaymentIntentID: &paymentIntentID, Amount: 5000, Status: &tt.refundStatus}}, nil
+				},
+				CreateRefundFn: func(ctx context.Context, refund *payments.Refund) (*payments.Refund, error) {
+					return refund, nil
+				},
+			}
+			stripeService := mock.StripeMockService{
+				CreateRefundFn: func(ctx context.Context, paymentID string, amount int) (*types.CreateRefundRes, error) {
+					stripeCalls++
+
+					return &types.CreateRefundRes{ID: "re_2", Amount: amount, Currency: "inr", Status: "pending"}, nil
+				},
+			}
+
+			payS := payments.NewService(mock.NewTxMock(), repo, stripeService)
+			_, err := payS.CreateRefund(context.TODO(), "pi_1")
+
+			if tt.wantKind != nil {
+				assert.True(t, errors.Is(err, tt.wantKind))
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.wantStripeCalls, stripeCalls)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package payment_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/stripe_pay_service/pkg/payments"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+)
+
+func TestValidateTransition(t *testing.T) {
+	cases := []struct {
+		name     string
+		current  payments.Status
+		next     payments.Status
+		wantErr  bool
+		wantCode int
+		wantKind *fault.Kind
+	}{
+		{
+			name:    "capture confirmed intent",
+			current: payments.StatusRequiresCapture,
+			next:    payments.StatusSucceeded,
+			wantErr: false,
+		},
+		{
+			name:    "refund captured intent",
+			current: payments.StatusSucceeded,
+			next:    payments.StatusRefunded,
+			wantErr: false,
+		},
+		{
+			name:     "capture canceled intent",
+			current:  payments.StatusCanceled,
+			next:     payments.StatusSucceeded,
+			wantErr:  true,
+			wantCode: 409,
+		},
+		{
+			name:     "refund uncaptured intent",
+			current:  payments.StatusRequiresCapture,
+			next:     payments.StatusRefunded,
+			wantErr:  true,
+			wantCode: 409,
+		},
+		{
+			name:     "capture twice",
+			current:  payments.StatusSucceeded,
+			next:     payments.StatusSucceeded,
+			wantErr:  true,
+			wantCode: 400,
+			wantKind: fault.ErrAlreadyCaptured,
+		},
+		{
+			name:     "refund twice",
+			current:  payments.StatusRefunded,
+			next:     payments.StatusRefunded,
+			wantErr:  true,
+			wantCode: 400,
+			wantKind: fault.ErrAlreadyRefunded,
+		},
+		{
+			name:     "capture refunded intent",
+			current:  payments.StatusRefunded,
+			next:     payments.StatusSucceeded,
+			wantErr:  true,
+			wantCode: 409,
+			wantKind: fault.ErrIllegalTransition,
+		},
+		{
+			name:     "unknown status",
+			current:  payments.StatusSucceeded,
+			next:     "paid",
+			wantErr:  true,
+			wantCode: 422,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := payments.ValidateTransition(tt.current, tt.next)
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if tt.wantErr {
+				httpErr, ok := err.(*fault.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tt.wantCode, httpErr.Status)
+			}
+
+			if tt.wantKind != nil {
+				assert.ErrorIs(t, err, tt.wantKind)
+			}
+		})
+	}
+}
@@ -3,12 +3,18 @@ package payments
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/stripe/stripe-go/v72"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/swagftw/stripe_pay_service/transaction"
 	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/metrics"
 	"github.com/swagftw/stripe_pay_service/utl/stripeclient"
+	"github.com/swagftw/stripe_pay_service/utl/tracing"
 )
 
 type service struct {
@@ -18,12 +24,18 @@ type service struct {
 }
 
 // CreatePaymentIntent creates a payment intent.
-func (s service) CreatePaymentIntent(ctx context.Context, intent *types.CreateIntentReq) (*types.CreateIntentRes, error) {
-	stripeIntent, err := s.stripeService.CreatePaymentIntent(intent)
+func (s service) CreatePaymentIntent(ctx context.Context, intent *types.CreateIntentReq) (_ *types.CreateIntentRes, err error) {
+	ctx, span := tracing.Start(ctx, "payments.CreatePaymentIntent")
+	defer func() { tracing.End(span, err) }()
+
+	stripeIntent, err := s.stripeService.CreatePaymentIntent(ctx, intent)
 	if err != nil {
 		return nil, err
 	}
 
+	ctx = types.WithPaymentID(ctx, stripeIntent.ID)
+	span.SetAttributes(attribute.String("payment.id", stripeIntent.ID))
+
 	payload, err := json.Marshal(stripeIntent)
 	if err != nil {
 		return nil, err
@@ -33,7 +45,8 @@ func (s service) CreatePaymentIntent(ctx context.Context, intent *types.CreateIn
 		Amount:     stripeIntent.Amount,
 		ProviderID: stripeIntent.ID,
 		Payload:    string(payload),
-		Status:     stripeIntent.Status,
+		Status:     Status(stripeIntent.Status),
+		Livemode:   stripeIntent.Livemode,
 	}
 	if val, ok := stripeIntent.ReceiptEmail.(string); ok {
 		dbIntent.Email = val
@@ -45,36 +58,57 @@ func (s service) CreatePaymentIntent(ctx context.Context, intent *types.CreateIn
 		return nil, err
 	}
 
+	metrics.IntentsCreated.WithLabelValues(stripeIntent.Currency).Inc()
+
 	return stripeIntent, err
 }
 
 // CapturePaymentIntent captures a payment intent.
-func (s service) CapturePaymentIntent(ctx context.Context, paymentID string) (*types.CaptureIntentRes, error) {
-	// get payment intent from db first
-	intent, err := s.repo.GetPayment(ctx, paymentID)
-	if err != nil {
-		return nil, err
-	}
+// the payment intent row stays locked until the capture is stored, so concurrent captures and refunds queue up.
+func (s service) CapturePaymentIntent(ctx context.Context, paymentID string) (_ *types.CaptureIntentRes, err error) {
+	ctx = types.WithPaymentID(ctx, paymentID)
 
-	// capture the payment intent using amount
-	capturedIntent, err := s.stripeService.CapturePaymentIntent(paymentID, intent.Amount)
-	if err != nil {
-		return nil, err
-	}
+	ctx, span := tracing.Start(ctx, "payments.CapturePaymentIntent", trace.WithAttributes(attribute.String("payment.id", paymentID)))
+	defer func() { tracing.End(span, err) }()
+
+	var capturedIntent *types.CaptureIntentRes
 
-	// update status
-	intent.Status = capturedIntent.Status
-	// update the payment intent in db
-	err = s.repo.UpdatePayment(ctx, intent)
+	err = s.tx.Run(ctx, func(ctx context.Context) error {
+		// get payment intent from db first
+		intent, err := s.repo.GetPaymentForUpdate(ctx, paymentID)
+		if err != nil {
+			return err
+		}
+
+		// reject intents which can not be captured before calling stripe
+		err = ValidateCapture(intent.Status)
+		if err != nil {
+			return err
+		}
+
+		// capture the payment intent using amount
+		capturedIntent, err = s.stripeService.CapturePaymentIntent(ctx, paymentID, intent.Amount)
+		if err != nil {
+			return err
+		}
+
+		// move the payment intent to the status stripe reports
+		return s.repo.TransitionPayment(ctx, intent, Status(capturedIntent.Status), ActorFromContext(ctx), "payment intent captured")
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return capturedIntent, err
+	metrics.CapturedAmount.WithLabelValues(capturedIntent.Currency).Add(float64(capturedIntent.AmountReceived))
+
+	return capturedIntent, nil
 }
 
-func (s service) GetPaymentIntents(ctx context.Context) (*types.GetIntentsRes, error) {
-	intents, err := s.stripeService.GetAllPaymentIntents()
+func (s service) GetPaymentIntents(ctx context.Context) (_ *types.GetIntentsRes, err error) {
+	ctx, span := tracing.Start(ctx, "payments.GetPaymentIntents")
+	defer func() { tracing.End(span, err) }()
+
+	intents, err := s.stripeService.GetAllPaymentIntents(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -85,33 +119,80 @@ func (s service) GetPaymentIntents(ctx context.Context) (*types.GetIntentsRes, e
 	return resp, nil
 }
 
-// CreateRefund initiates a refund for a payment intent.
-func (s service) CreateRefund(ctx context.Context, id string) (*types.CreateRefundRes, error) {
-	// get payment intent from db first
+// GetPaymentIntent returns the stored payment intent of the authenticated merchant, in its current status.
+func (s service) GetPaymentIntent(ctx context.Context, id string) (_ *types.PaymentIntent, err error) {
+	ctx = types.WithPaymentID(ctx, id)
+
+	ctx, span := tracing.Start(ctx, "payments.GetPaymentIntent", trace.WithAttributes(attribute.String("payment.id", id)))
+	defer func() { tracing.End(span, err) }()
+
 	intent, err := s.repo.GetPayment(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	// create refund
-	refund, err := s.stripeService.CreateRefund(id, intent.Amount)
-	if err != nil {
-		return nil, err
+	// the payload is the stripe intent as it was created, the row holds what changed since
+	res := new(types.PaymentIntent)
+
+	if intent.Payload != "" {
+		err = json.Unmarshal([]byte(intent.Payload), res)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	res.ID = intent.ProviderID
+	res.Amount = intent.Amount
+	res.Status = string(intent.Status)
+	res.Livemode = intent.Livemode
+
+	return res, nil
+}
+
+// CreateRefund initiates a refund for a payment intent.
+// the payment intent row stays locked until the refund is stored, so concurrent captures and refunds queue up.
+func (s service) CreateRefund(ctx context.Context, id string) (_ *types.CreateRefundRes, err error) {
+	ctx = types.WithPaymentID(ctx, id)
+
+	ctx, span := tracing.Start(ctx, "payments.CreateRefund", trace.WithAttributes(attribute.String("payment.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	var refund *types.CreateRefundRes
+
 	err = s.tx.Run(ctx, func(ctx context.Context) error {
-		// update status.
-		// this actually does not refund immediately but for the sake of demo update the state to refunded here.
-		if refund.Status == string(stripe.RefundStatusSucceeded) {
-			intent.Status = "refunded"
+		// get payment intent from db first
+		intent, err := s.repo.GetPaymentForUpdate(ctx, id)
+		if err != nil {
+			return err
 		}
 
-		// update the payment intent in db
-		err = s.repo.UpdatePayment(ctx, intent)
+		// only captured payment intents can be refunded
+		err = ValidateTransition(intent.Status, StatusRefunded)
 		if err != nil {
 			return err
 		}
 
+		// a pending refund leaves the intent succeeded, so look at the refunds already issued before calling stripe
+		err = s.validateNoOpenRefund(ctx, intent)
+		if err != nil {
+			return err
+		}
+
+		// create refund
+		refund, err = s.stripeService.CreateRefund(ctx, id, intent.Amount)
+		if err != nil {
+			return err
+		}
+
+		// update status.
+		// this actually does not refund immediately but for the sake of demo update the state to refunded here.
+		if refund.Status == string(stripe.RefundStatusSucceeded) {
+			err = s.repo.TransitionPayment(ctx, intent, StatusRefunded, ActorFromContext(ctx), "refund "+refund.ID+" succeeded")
+			if err != nil {
+				return err
+			}
+		}
+
 		// create refund entry
 		refundEntry := &Refund{
 			ProviderID:      &refund.ID,
@@ -124,8 +205,16 @@ func (s service) CreateRefund(ctx context.Context, id string) (*types.CreateRefu
 
 		return err
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	// pending refunds are counted too, stripe settles them later
+	if refund.Status == string(stripe.RefundStatusSucceeded) || refund.Status == string(stripe.RefundStatusPending) {
+		metrics.RefundedAmount.WithLabelValues(refund.Currency).Add(float64(refund.Amount))
+	}
 
-	return refund, err
+	return refund, nil
 }
 
 // NewService creates a new payments service.
@@ -136,3 +225,33 @@ func NewService(tx transaction.Transaction, repo Repository, stripeService strip
 		stripeService: stripeService,
 	}
 }
+
+// validateNoOpenRefund returns a fault error when a refund of the payment intent is pending or succeeded.
+// failed and canceled refunds do not count, the payment may be refunded again after them.
+func (s service) validateNoOpenRefund(ctx context.Context, intent *PaymentIntent) error {
+	refunds, err := s.repo.GetRefunds(ctx, intent.ProviderID)
+	if err != nil {
+		return err
+	}
+
+	for _, refund := range refunds {
+		if refund.Status == nil {
+			continue
+		}
+
+		switch stripe.RefundStatus(*refund.Status) {
+		case stripe.RefundStatusSucceeded:
+			err = fmt.Errorf("refund %s of payment intent %s already succeeded", *refund.ProviderID, intent.ProviderID)
+
+			return fault.ErrAlreadyRefunded.New("payments", "payment intent already refunded", err)
+		case stripe.RefundStatusFailed, stripe.RefundStatusCanceled:
+			continue
+		default:
+			err = fmt.Errorf("refund %s of payment intent %s is %s", *refund.ProviderID, intent.ProviderID, *refund.Status)
+
+			return fault.ErrRefundPending.New("payments", "wait for the pending refund to settle", err)
+		}
+	}
+
+	return nil
+}
@@ -2,11 +2,13 @@ package postgres
 
 import (
 	"context"
-	"net/http"
+	"fmt"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/swagftw/stripe_pay_service/pkg/payments"
+	"github.com/swagftw/stripe_pay_service/types"
 	"github.com/swagftw/stripe_pay_service/utl/fault"
 	"github.com/swagftw/stripe_pay_service/utl/storage"
 )
@@ -15,44 +17,190 @@ type repository struct {
 	db *gorm.DB
 }
 
-// CreatePayment creates a payment intent.
+// CreatePayment creates a payment intent for the authenticated merchant and records its initial status.
 func (r repository) CreatePayment(ctx context.Context, payment *payments.PaymentIntent) error {
+	merchantID, err := merchantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	payment.MerchantID = merchantID
+
 	db := storage.GetGormDBFromContext(ctx, r.db)
-	err := db.Model(payment).Create(payment).Error
 
-	return err
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(payment).Create(payment).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&payments.StatusHistory{
+			PaymentIntentID: payment.ID,
+			ToStatus:        payment.Status,
+			Actor:           payments.ActorFromContext(ctx),
+			Reason:          "payment intent created",
+		}).Error
+	})
 }
 
-// GetPayment gets the payment intent.
+// GetPayment gets the payment intent of the authenticated merchant, from a replica outside of transactions.
 func (r repository) GetPayment(ctx context.Context, id string) (*payments.PaymentIntent, error) {
+	db := storage.GetReadDBFromContext(ctx, r.db)
+
+	return getPayment(ctx, db, id)
+}
+
+// GetPaymentForUpdate gets the payment intent and locks its row until the surrounding transaction ends.
+// it must be called inside transaction.Transaction, otherwise the lock is released immediately.
+func (r repository) GetPaymentForUpdate(ctx context.Context, id string) (*payments.PaymentIntent, error) {
 	db := storage.GetGormDBFromContext(ctx, r.db)
 
+	return getPayment(ctx, db.Clauses(clause.Locking{Strength: "UPDATE"}), id)
+}
+
+func getPayment(ctx context.Context, db *gorm.DB, id string) (*payments.PaymentIntent, error) {
+	merchantID, err := merchantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	payment := new(payments.PaymentIntent)
-	err := db.Where("provider_id = ?", id).First(payment).Error
+	err = db.Where("provider_id = ? AND merchant_id = ?", id, merchantID).First(payment).Error
 
 	if err == gorm.ErrRecordNotFound {
-		return nil, fault.New(http.StatusNotFound, "payment_repo", "payment intent not found", "provide valid intent id", "INVALID_PAYMENT_INTENT_ID", err)
+		return nil, fault.ErrPaymentNotFound.New("payment_repo", "provide valid intent id", err)
 	}
 
 	return payment, err
 }
 
-// UpdatePayment updates the payment intent.
+// UpdatePayment updates the payment intent if nobody else updated it since it was read.
+// status is never written here, status changes go through TransitionPayment.
 func (r repository) UpdatePayment(ctx context.Context, payment *payments.PaymentIntent) error {
+	merchantID, err := merchantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	db := storage.GetGormDBFromContext(ctx, r.db)
+
+	current := payment.Version
+	payment.Version++
+
+	res := db.Model(payment).
+		Where("version = ? AND merchant_id = ?", current, merchantID).
+		Omit("status", "merchant_id").
+		Updates(payment)
+	if res.Error == nil && res.RowsAffected == 0 {
+		res.Error = errConcurrentUpdate(payment.ID, current)
+	}
+
+	if res.Error != nil {
+		payment.Version = current
+
+		return res.Error
+	}
+
+	return nil
+}
+
+// TransitionPayment moves the payment intent to the next status and records the transition in the status history.
+func (r repository) TransitionPayment(ctx context.Context, payment *payments.PaymentIntent, next payments.Status, actor, reason string) error {
+	current := payment.Status
+
+	err := payments.ValidateTransition(current, next)
+	if err != nil {
+		return err
+	}
+
+	merchantID, err := merchantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
 	db := storage.GetGormDBFromContext(ctx, r.db)
-	err := db.Updates(payment).Error
 
-	return err
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// only move the row if nobody else touched it in the meantime
+		res := tx.Model(&payments.PaymentIntent{}).
+			Where("id = ? AND version = ? AND merchant_id = ?", payment.ID, payment.Version, merchantID).
+			Updates(map[string]interface{}{
+				"status":  next,
+				"version": gorm.Expr("version + 1"),
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			return errConcurrentUpdate(payment.ID, payment.Version)
+		}
+
+		return tx.Create(&payments.StatusHistory{
+			PaymentIntentID: payment.ID,
+			FromStatus:      current,
+			ToStatus:        next,
+			Actor:           actor,
+			Reason:          reason,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	payment.Status = next
+	payment.Version++
+
+	return nil
+}
+
+// errConcurrentUpdate is returned when the stored version no longer matches the one the caller read.
+func errConcurrentUpdate(id string, version int) error {
+	err := fmt.Errorf("payment intent %s was modified after version %d was read", id, version)
+
+	return fault.ErrConcurrentUpdate.New("payment_repo", "reload the payment intent and retry", err)
 }
 
-// CreateRefund creates a refund.
+// CreateRefund creates a refund for the authenticated merchant.
 func (r repository) CreateRefund(ctx context.Context, refund *payments.Refund) (*payments.Refund, error) {
+	merchantID, err := merchantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	refund.MerchantID = merchantID
+
 	db := storage.GetGormDBFromContext(ctx, r.db)
-	err := db.Model(&payments.Refund{}).Create(refund).Error
+	err = db.Model(&payments.Refund{}).Create(refund).Error
 
 	return refund, err
 }
 
+// GetRefunds gets the refunds of the authenticated merchant for the payment intent with the stripe id paymentIntentID.
+func (r repository) GetRefunds(ctx context.Context, paymentIntentID string) ([]*payments.Refund, error) {
+	merchantID, err := merchantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db := storage.GetGormDBFromContext(ctx, r.db)
+
+	var refunds []*payments.Refund
+	err = db.Where("payment_intent_id = ? AND merchant_id = ?", paymentIntentID, merchantID).Find(&refunds).Error
+
+	return refunds, err
+}
+
+// merchantFromContext returns the authenticated merchant every query is scoped by.
+func merchantFromContext(ctx context.Context) (string, error) {
+	merchantID, ok := types.MerchantIDFromContext(ctx)
+	if !ok {
+		return "", fault.ErrMerchantRequired.New("payment_repo", "authenticate as a merchant", types.ErrMerchantRequired)
+	}
+
+	return merchantID, nil
+}
+
 // NewPaymentsRepo returns a new payment repository.
 func NewPaymentsRepo(db *gorm.DB) payments.Repository {
 	return &repository{
@@ -2,21 +2,33 @@ package postgres
 
 import (
 	"context"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/swagftw/stripe_pay_service/pkg/payments"
+	"github.com/swagftw/stripe_pay_service/types"
 	"github.com/swagftw/stripe_pay_service/utl/config"
 	"github.com/swagftw/stripe_pay_service/utl/constant"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
 	"github.com/swagftw/stripe_pay_service/utl/logger"
 	"github.com/swagftw/stripe_pay_service/utl/storage"
 )
 
+// encryptionKeyOverride sets the encryption key the config requires, .env leaves it empty.
+const encryptionKeyOverride = "security.encryptionKey=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+// merchantCtx is the context of an authenticated merchant, every repository query is scoped by it.
+var merchantCtx = context.WithValue(context.Background(), constant.TxKey(constant.PrincipalKey), &types.Principal{
+	ClientID:   "mr_test",
+	MerchantID: "mr_test",
+})
+
 func TestCreatePayment(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../../../utl/config/config.local.yaml", "../../../../.env")
+	err := config.InitConfig("../../../../utl/config/config.local.yaml", "../../../../.env", encryptionKeyOverride)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,7 +69,7 @@ func TestCreatePayment(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			repo := NewPaymentsRepo(db)
-			err = repo.CreatePayment(context.TODO(), tt.data)
+			err = repo.CreatePayment(merchantCtx, tt.data)
 			assert.Equal(t, tt.wantErr, err != nil)
 		})
 	}
@@ -66,7 +78,7 @@ func TestCreatePayment(t *testing.T) {
 func TestGetPayment(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../../../utl/config/config.local.yaml", "../../../../.env")
+	err := config.InitConfig("../../../../utl/config/config.local.yaml", "../../../../.env", encryptionKeyOverride)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -106,18 +118,18 @@ func TestGetPayment(t *testing.T) {
 					Amount:     100,
 				}
 
-				err = repo.CreatePayment(context.TODO(), intent)
+				err = repo.CreatePayment(merchantCtx, intent)
 				if err != nil {
 					t.Error(err)
 				}
 
-				intent, err = repo.GetPayment(context.TODO(), intent.ProviderID)
+				intent, err = repo.GetPayment(merchantCtx, intent.ProviderID)
 				assert.Equal(t, tt.wantErr, err != nil)
 				assert.Equal(t, tt.providerId, intent.ProviderID)
 				return
 			}
 
-			_, err = repo.GetPayment(context.TODO(), tt.providerId)
+			_, err = repo.GetPayment(merchantCtx, tt.providerId)
 			assert.Equal(t, tt.wantErr, err != nil)
 		})
 	}
@@ -126,7 +138,7 @@ func TestGetPayment(t *testing.T) {
 func TestUpdatePayment(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../../../utl/config/config.local.yaml", "../../../../.env")
+	err := config.InitConfig("../../../../utl/config/config.local.yaml", "../../../../.env", encryptionKeyOverride)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -147,7 +159,7 @@ func TestUpdatePayment(t *testing.T) {
 		},
 		{
 			name:    "invalid id",
-			wantErr: false,
+			wantErr: true,
 			data: &payments.PaymentIntent{
 				ID: "asd",
 			},
@@ -171,24 +183,114 @@ func TestUpdatePayment(t *testing.T) {
 				Payload:    "null",
 			}
 
-			err = payS.CreatePayment(context.TODO(), intent)
+			err = payS.CreatePayment(merchantCtx, intent)
 			intent.Status = "succeeded"
 			if tt.data != nil {
-				err := payS.UpdatePayment(context.TODO(), tt.data)
+				err := payS.UpdatePayment(merchantCtx, tt.data)
 				assert.Equal(t, tt.data.ProviderID, "")
 				assert.Equal(t, tt.wantErr, err != nil)
 				return
 			}
-			err := payS.UpdatePayment(context.TODO(), intent)
+			err := payS.UpdatePayment(merchantCtx, intent)
 			assert.Equal(t, tt.wantErr, err != nil)
 		})
 	}
 }
 
+func TestUpdatePaymentConflict(t *testing.T) {
+	logger.InitLogger()
+
+	err := config.InitConfig("../../../../utl/config/config.local.yaml", "../../../../.env", encryptionKeyOverride)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		t.Error(err)
+	}
+
+	repo := NewPaymentsRepo(db)
+
+	intent := &payments.PaymentIntent{
+		Amount:     100,
+		ProviderID: "pi_test_conflict",
+		Email:      "asd@asd.com",
+		Status:     payments.StatusRequiresCapture,
+		Payload:    "null",
+	}
+
+	err = repo.CreatePayment(merchantCtx, intent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale := *intent
+
+	intent.Email = "first@asd.com"
+	err = repo.UpdatePayment(merchantCtx, intent)
+	assert.Nil(t, err)
+
+	// the stale copy still holds the old version and must not overwrite the first update
+	stale.Email = "second@asd.com"
+	err = repo.UpdatePayment(merchantCtx, &stale)
+	assert.NotNil(t, err)
+
+	httpErr, ok := err.(*fault.HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusConflict, httpErr.Status)
+
+	err = repo.TransitionPayment(merchantCtx, &stale, payments.StatusSucceeded, payments.ActorAPI, "test")
+	assert.NotNil(t, err)
+
+	err = repo.TransitionPayment(merchantCtx, intent, payments.StatusSucceeded, payments.ActorAPI, "test")
+	assert.Nil(t, err)
+}
+
+func TestMerchantIsolation(t *testing.T) {
+	logger.InitLogger()
+
+	err := config.InitConfig("../../../../utl/config/config.local.yaml", "../../../../.env", encryptionKeyOverride)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		t.Error(err)
+	}
+
+	repo := NewPaymentsRepo(db)
+
+	err = repo.CreatePayment(merchantCtx, &payments.PaymentIntent{
+		Amount:     100,
+		ProviderID: "pi_test_isolation",
+		Status:     payments.StatusRequiresCapture,
+		Payload:    "null",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherMerchantCtx := context.WithValue(context.Background(), constant.TxKey(constant.PrincipalKey), &types.Principal{
+		ClientID:   "mr_other",
+		MerchantID: "mr_other",
+	})
+
+	_, err = repo.GetPayment(otherMerchantCtx, "pi_test_isolation")
+	assert.NotNil(t, err)
+
+	_, err = repo.GetPayment(context.Background(), "pi_test_isolation")
+	assert.NotNil(t, err)
+
+	_, err = repo.GetPayment(merchantCtx, "pi_test_isolation")
+	assert.Nil(t, err)
+}
+
 func TestCreateRefund(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../../../utl/config/config.local.yaml", "../../../../.env")
+	err := config.InitConfig("../../../../utl/config/config.local.yaml", "../../../../.env", encryptionKeyOverride)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -228,7 +330,7 @@ func TestCreateRefund(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			payS := NewPaymentsRepo(db)
-			_, err = payS.CreateRefund(context.TODO(), tt.data)
+			_, err = payS.CreateRefund(merchantCtx, tt.data)
 			assert.Equal(t, tt.wantErr, err != nil)
 		})
 	}
@@ -2,7 +2,11 @@ package payments
 
 import (
 	"context"
+	"time"
 
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/utl/redact"
 	"github.com/swagftw/stripe_pay_service/utl/storage"
 )
 
@@ -11,36 +15,76 @@ type (
 	Repository interface {
 		CreatePayment(ctx context.Context, payment *PaymentIntent) error
 		UpdatePayment(ctx context.Context, payment *PaymentIntent) error
+		TransitionPayment(ctx context.Context, payment *PaymentIntent, next Status, actor, reason string) error
 		GetPayment(ctx context.Context, id string) (*PaymentIntent, error)
+		GetPaymentForUpdate(ctx context.Context, id string) (*PaymentIntent, error)
 		CreateRefund(ctx context.Context, refund *Refund) (*Refund, error)
+		GetRefunds(ctx context.Context, paymentIntentID string) ([]*Refund, error)
 	}
 
 	// PaymentIntent is the db model for the payment intent.
 	PaymentIntent struct {
 		ID         string `gorm:"primaryKey;default:('pi_' || generate_uid(12));not null"`
+		MerchantID string `gorm:"index"`
 		Amount     int
 		ProviderID string
 		Email      string
-		Status     string
+		Status     Status
 		Payload    string `gorm:"type:jsonb"`
+		// Livemode is set when the intent was made with a live stripe key, test intents are left out of live reporting.
+		Livemode bool `gorm:"not null;default:false"`
+		// Version is bumped on every update and guards against lost updates.
+		Version int `gorm:"not null;default:1"`
 		storage.GormBase
 	}
 
 	// Refund is the db model for the refund.
 	Refund struct {
 		ID              string  `gorm:"primaryKey; default:('rf_' || generate_uid(12))"`
+		MerchantID      string  `gorm:"index"`
 		ProviderID      *string `gorm:"not null"`
-		PaymentIntentID *string `gorm:"not null"`
+		PaymentIntentID *string `gorm:"not null;index"`
 		Amount          int     `gorm:"not null"`
 		Status          *string `gorm:"not null"`
 		storage.GormBase
 	}
+
+	// StatusHistory is the db model for a single payment intent status transition.
+	StatusHistory struct {
+		ID              string `gorm:"primaryKey;default:('sh_' || generate_uid(12))"`
+		PaymentIntentID string `gorm:"not null;index"`
+		FromStatus      Status
+		ToStatus        Status `gorm:"not null"`
+		Actor           string `gorm:"not null"`
+		Reason          string
+		CreatedAt       time.Time
+	}
 )
 
 func (*PaymentIntent) TableName() string {
 	return "payment.payment_intents"
 }
 
+// BeforeSave masks the secrets and PII of the stripe payload before it is written.
+func (p *PaymentIntent) BeforeSave(*gorm.DB) error {
+	if p.Payload == "" {
+		return nil
+	}
+
+	payload, err := redact.JSON([]byte(p.Payload))
+	if err != nil {
+		return err
+	}
+
+	p.Payload = string(payload)
+
+	return nil
+}
+
 func (*Refund) TableName() string {
 	return "payment.refunds"
 }
+
+func (*StatusHistory) TableName() string {
+	return "payment.status_history"
+}
@@ -0,0 +1,135 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+)
+
+// Status is the lifecycle status of a payment intent.
+type Status string
+
+// statuses mirrored from stripe, plus refunded which is tracked only by this service.
+const (
+	StatusRequiresPaymentMethod Status = "requires_payment_method"
+	StatusRequiresConfirmation  Status = "requires_confirmation"
+	StatusRequiresAction        Status = "requires_action"
+	StatusProcessing            Status = "processing"
+	StatusRequiresCapture       Status = "requires_capture"
+	StatusCanceled              Status = "canceled"
+	StatusSucceeded             Status = "succeeded"
+	StatusRefunded              Status = "refunded"
+)
+
+// ActorAPI is recorded in the status history for changes made by unauthenticated callers.
+const ActorAPI = "api"
+
+// ActorFromContext returns the actor to record for changes made with ctx,
+// the authenticated client when there is one and ActorAPI otherwise.
+func ActorFromContext(ctx context.Context) string {
+	if principal, ok := types.PrincipalFromContext(ctx); ok {
+		return "client:" + principal.ClientID
+	}
+
+	return ActorAPI
+}
+
+// transitions holds the allowed target statuses for every status.
+// statuses missing from the table (or mapped to nothing) are terminal.
+var transitions = map[Status][]Status{
+	StatusRequiresPaymentMethod: {StatusRequiresConfirmation, StatusRequiresAction, StatusProcessing, StatusRequiresCapture, StatusSucceeded, StatusCanceled},
+	StatusRequiresConfirmation:  {StatusRequiresPaymentMethod, StatusRequiresAction, StatusProcessing, StatusRequiresCapture, StatusSucceeded, StatusCanceled},
+	StatusRequiresAction:        {StatusRequiresPaymentMethod, StatusProcessing, StatusRequiresCapture, StatusSucceeded, StatusCanceled},
+	StatusProcessing:            {StatusRequiresPaymentMethod, StatusRequiresCapture, StatusSucceeded, StatusCanceled},
+	StatusRequiresCapture:       {StatusProcessing, StatusSucceeded, StatusCanceled},
+	StatusSucceeded:             {StatusRefunded},
+	StatusCanceled:              {},
+	StatusRefunded:              {},
+}
+
+// IsValid reports whether the status is known to the state machine.
+func (s Status) IsValid() bool {
+	_, ok := transitions[s]
+
+	return ok
+}
+
+// CanTransition reports whether moving from s to next is allowed.
+func (s Status) CanTransition(next Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateTransition returns a fault error when moving from the current status to next is not allowed.
+func ValidateTransition(current, next Status) error {
+	if !next.IsValid() {
+		err := fmt.Errorf("unknown payment status %q", next)
+
+		return fault.ErrUnknownStatus.New("payments", err.Error(), err)
+	}
+
+	// repeating a capture or refund is reported as such rather than as an illegal transition
+	switch {
+	case current == StatusSucceeded && next == StatusSucceeded:
+		return fault.ErrAlreadyCaptured.New("payments", "payment intent already captured", errors.New("payment intent is already succeeded"))
+	case current == StatusRefunded && next == StatusRefunded:
+		return fault.ErrAlreadyRefunded.New("payments", "payment intent already refunded", errors.New("payment intent is already refunded"))
+	}
+
+	if !current.CanTransition(next) {
+		err := fmt.Errorf("illegal payment status transition from %q to %q", current, next)
+
+		return fault.ErrIllegalTransition.New("payments", transitionHint(current, next), err)
+	}
+
+	return nil
+}
+
+// capturable holds the statuses a capture may start from.
+// intents are stored before the customer confirms them and no webhooks move them on, so the capture confirms the
+// intent with its payment method and captures it in one go. intents stripe is still working on (requires_action,
+// processing) are left alone.
+var capturable = map[Status]bool{
+	StatusRequiresPaymentMethod: true,
+	StatusRequiresConfirmation:  true,
+	StatusRequiresCapture:       true,
+}
+
+// ValidateCapture returns a fault error when a payment intent in the current status can not be captured.
+func ValidateCapture(current Status) error {
+	if capturable[current] {
+		return nil
+	}
+
+	// reports already captured, canceled and refunded intents the same way a transition would
+	err := ValidateTransition(current, StatusSucceeded)
+	if err != nil {
+		return err
+	}
+
+	err = fmt.Errorf("payment intent in status %q can not be captured", current)
+
+	return fault.ErrUnexpectedState.New("payments", "payment intent is "+string(current)+" and can not be captured yet", err)
+}
+
+// transitionHint explains the most common illegal transitions in terms the caller can act on.
+func transitionHint(current, next Status) string {
+	switch {
+	case current == StatusCanceled:
+		return "payment intent is canceled"
+	case current == StatusRefunded:
+		return "payment intent is already refunded"
+	case next == StatusRefunded:
+		return "payment intent must be captured before it can be refunded"
+	}
+
+	return fmt.Sprintf("payment intent cannot move from %s to %s", current, next)
+}
@@ -18,14 +18,12 @@ func (t *txn) Run(ctx context.Context, fn func(ctx context.Context) error) error
 	db := t.db
 
 	// check if the transaction is already in progress
-	if ctx.Value(constant.PostgresTxKey) != nil {
-		if postgresTx, ok := ctx.Value(constant.PostgresTxKey).(*gorm.DB); ok {
-			db = postgresTx
-		}
+	if postgresTx, ok := ctx.Value(constant.TxKey(constant.PostgresTxKey)).(*gorm.DB); ok {
+		db = postgresTx
 	}
 
 	// return new transaction from the given db (which may have another transaction in progress)
-	return db.Transaction(func(tx *gorm.DB) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		ctx = context.WithValue(ctx, constant.TxKey(constant.PostgresTxKey), tx)
 
 		return fn(ctx)
@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+type HTTP struct{}
+
+// LogLevel is the minimum level logged.
+type LogLevel struct {
+	Level string `json:"level" validate:"required"`
+}
+
+// InitHTTPHandlers initializes HTTP handlers for operating the service
+// every admin route needs the admin scope.
+func InitHTTPHandlers(v1 *echo.Group, authn echo.MiddlewareFunc) {
+	handler := &HTTP{}
+
+	adminGroup := v1.Group("/admin", authn, server.RequireScope(types.ScopeAdmin))
+
+	adminGroup.GET("/log/level", handler.getLogLevel)
+
+	adminGroup.PUT("/log/level", handler.setLogLevel)
+}
+
+func (h HTTP) getLogLevel(c echo.Context) error {
+	return c.JSON(http.StatusOK, LogLevel{Level: logger.Level()})
+}
+
+func (h HTTP) setLogLevel(c echo.Context) error {
+	req := new(LogLevel)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	err := logger.SetLevel(req.Level)
+	if err != nil {
+		return fault.ErrInvalidLogLevel.New("admin", "use one of debug, info, warn, error", err)
+	}
+
+	logger.Logger.Info(server.ToGoContext(c), "log level changed", req.Level)
+
+	return c.JSON(http.StatusOK, LogLevel{Level: logger.Level()})
+}
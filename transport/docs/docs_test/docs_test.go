@@ -0,0 +1,45 @@
+package docs_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swagftw/stripe_pay_service/transport/docs"
+	"github.com/swagftw/stripe_pay_service/utl/openapi"
+)
+
+func TestUIAssets(t *testing.T) {
+	e := echo.New()
+	require.NoError(t, docs.InitHTTPHandlers(e.Group("/api/v1"), &openapi.Document{}, true))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		return rec
+	}
+
+	page := get("/api/v1" + docs.UIPath)
+	require.Equal(t, http.StatusOK, page.Code)
+
+	// the page loads nothing from outside the service
+	assert.NotContains(t, page.Body.String(), "://")
+
+	refs := regexp.MustCompile(`(?:href|src)="([^"]+)"`).FindAllStringSubmatch(page.Body.String(), -1)
+	require.Len(t, refs, 2)
+
+	for _, ref := range refs {
+		// relative to the page, which is served from /api/v1
+		asset := get("/api/v1/" + ref[1])
+		assert.Equal(t, http.StatusOK, asset.Code, ref[1])
+		assert.NotEmpty(t, asset.Body.Bytes(), ref[1])
+	}
+
+	assert.NotEqual(t, http.StatusOK, get("/api/v1"+docs.UIPath+"/swagger-ui.js.map").Code)
+}
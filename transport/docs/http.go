@@ -0,0 +1,79 @@
+package docs
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	swaggerFiles "github.com/swaggo/files/v2"
+
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/openapi"
+)
+
+// paths of the documentation, relative to /api/v1.
+const (
+	SpecPath = "/openapi.json"
+	UIPath   = "/docs"
+)
+
+//go:embed ui.html
+var ui []byte
+
+// assets are the swagger ui files the page loads, served from the version pinned in go.mod
+// rather than a cdn, with their content type.
+var assets = map[string]string{
+	"swagger-ui.css":       "text/css; charset=utf-8",
+	"swagger-ui-bundle.js": "text/javascript; charset=utf-8",
+}
+
+type HTTP struct {
+	spec []byte
+}
+
+// InitHTTPHandlers serves the openapi document, and a page browsing it when ui is set.
+// the document is rendered once, it does not change while the service runs.
+func InitHTTPHandlers(v1 *echo.Group, doc *openapi.Document, ui bool) error {
+	spec, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	handler := &HTTP{spec: spec}
+
+	v1.GET(SpecPath, handler.getSpec)
+
+	if ui {
+		v1.GET(UIPath, handler.getUI)
+		v1.GET(UIPath+"/:asset", handler.getAsset)
+	}
+
+	return nil
+}
+
+func (h HTTP) getSpec(c echo.Context) error {
+	return c.JSONBlob(http.StatusOK, h.spec)
+}
+
+func (h HTTP) getUI(c echo.Context) error {
+	return c.HTMLBlob(http.StatusOK, ui)
+}
+
+func (h HTTP) getAsset(c echo.Context) error {
+	name := c.Param("asset")
+
+	contentType, ok := assets[name]
+	if !ok {
+		return fault.ErrNotFound.New("docs", "check the url", errors.New("unknown docs asset "+name))
+	}
+
+	asset, err := fs.ReadFile(swaggerFiles.FS, name)
+	if err != nil {
+		return fault.ErrInternal.New("docs", "something went wrong", err)
+	}
+
+	return c.Blob(http.StatusOK, contentType, asset)
+}
@@ -0,0 +1,220 @@
+// Package grpc serves the payment service over grpc, next to the http api.
+package grpc
+
+//go:generate protoc -I proto --go_out=paymentspb --go_opt=paths=source_relative --go-grpc_out=paymentspb --go-grpc_opt=paths=source_relative proto/payments.proto
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc"
+
+	"github.com/swagftw/stripe_pay_service/transport/grpc/paymentspb"
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+// methodScopes are the scopes each method needs, methods missing here are refused.
+var methodScopes = map[string]string{
+	paymentspb.PaymentService_CreatePaymentIntent_FullMethodName:  types.ScopePaymentsWrite,
+	paymentspb.PaymentService_CapturePaymentIntent_FullMethodName: types.ScopePaymentsCapture,
+	paymentspb.PaymentService_ListPaymentIntents_FullMethodName:   types.ScopePaymentsRead,
+	paymentspb.PaymentService_GetPaymentIntent_FullMethodName:     types.ScopePaymentsRead,
+	paymentspb.PaymentService_CreateRefund_FullMethodName:         types.ScopeRefundsWrite,
+}
+
+// methodGroups are the rate limit groups of the methods, the groups of their http routes.
+var methodGroups = map[string]string{
+	paymentspb.PaymentService_CreatePaymentIntent_FullMethodName:  "payments",
+	paymentspb.PaymentService_CapturePaymentIntent_FullMethodName: "captures",
+	paymentspb.PaymentService_ListPaymentIntents_FullMethodName:   "payments",
+	paymentspb.PaymentService_GetPaymentIntent_FullMethodName:     "payments",
+	paymentspb.PaymentService_CreateRefund_FullMethodName:         "refunds",
+}
+
+// authGroup limits the calls of every ip before they are authenticated.
+const authGroup = "auth"
+
+type paymentServer struct {
+	paymentspb.UnimplementedPaymentServiceServer
+
+	service   types.PaymentService
+	validator *validator.Validate
+}
+
+// NewServer returns a grpc server exposing the payment service.
+// calls are authenticated with an api key, or a token of the identity provider when verifier is set,
+// each method then checks the scope it needs. calls are rate limited like the http routes when limiter is set,
+// per ip before they are authenticated and per caller in the group of the method after.
+// errors are answered with the grpc code matching their status, panics as internal errors.
+func NewServer(service types.PaymentService, authService types.AuthService, verifier *server.JWTVerifier, limiter *server.RateLimiter) *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		requestIDInterceptor,
+		errorInterceptor,
+		recoveryInterceptor,
+		rateLimitInterceptor(limiter, func(ctx context.Context, _ string) (string, string) {
+			return authGroup, "ip:" + peerIP(ctx)
+		}),
+		authInterceptor(authService, verifier),
+		rateLimitInterceptor(limiter, func(ctx context.Context, method string) (string, string) {
+			key, _ := server.CallerKey(ctx)
+
+			return methodGroups[method], key
+		}),
+	))
+
+	paymentspb.RegisterPaymentServiceServer(srv, &paymentServer{
+		service:   service,
+		validator: server.NewValidator(),
+	})
+
+	return srv
+}
+
+func (s *paymentServer) CreatePaymentIntent(ctx context.Context, req *paymentspb.CreatePaymentIntentRequest) (*paymentspb.PaymentIntent, error) {
+	intentReq := &types.CreateIntentReq{
+		Amount:      req.GetAmount(),
+		Currency:    req.GetCurrency(),
+		Email:       req.GetEmail(),
+		Phone:       req.GetPhone(),
+		Description: req.GetDescription(),
+	}
+
+	err := s.validator.Struct(intentReq)
+	if err != nil {
+		var errs validator.ValidationErrors
+		if errors.As(err, &errs) {
+			return nil, server.ValidationError(errs)
+		}
+
+		return nil, err
+	}
+
+	res, err := s.service.CreatePaymentIntent(ctx, intentReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return createdIntent(res), nil
+}
+
+func (s *paymentServer) CapturePaymentIntent(ctx context.Context, req *paymentspb.CapturePaymentIntentRequest) (*paymentspb.PaymentIntent, error) {
+	res, err := s.service.CapturePaymentIntent(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return capturedIntent(res), nil
+}
+
+func (s *paymentServer) ListPaymentIntents(ctx context.Context, _ *paymentspb.ListPaymentIntentsRequest) (*paymentspb.ListPaymentIntentsResponse, error) {
+	res, err := s.service.GetPaymentIntents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	intents := make([]*paymentspb.PaymentIntent, 0, len(res.Intents))
+	for _, intent := range res.Intents {
+		intents = append(intents, paymentIntent(intent))
+	}
+
+	return &paymentspb.ListPaymentIntentsResponse{Intents: intents}, nil
+}
+
+func (s *paymentServer) GetPaymentIntent(ctx context.Context, req *paymentspb.GetPaymentIntentRequest) (*paymentspb.PaymentIntent, error) {
+	intent, err := s.service.GetPaymentIntent(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return paymentIntent(intent), nil
+}
+
+func (s *paymentServer) CreateRefund(ctx context.Context, req *paymentspb.CreateRefundRequest) (*paymentspb.Refund, error) {
+	res, err := s.service.CreateRefund(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	// the refund holds the expanded intent, which is the one refunded
+	return refund(res, req.GetId()), nil
+}
+
+// createdIntent, capturedIntent and paymentIntent map the intent shaped domain types to the same message,
+// the optional fields are read through text whichever type they have in the domain type.
+func createdIntent(res *types.CreateIntentRes) *paymentspb.PaymentIntent {
+	return &paymentspb.PaymentIntent{
+		Id:               res.ID,
+		Amount:           int64(res.Amount),
+		AmountCapturable: int64(res.AmountCapturable),
+		AmountReceived:   int64(res.AmountReceived),
+		Currency:         res.Currency,
+		Status:           res.Status,
+		CaptureMethod:    res.CaptureMethod,
+		ClientSecret:     text(res.ClientSecret),
+		Description:      text(res.Description),
+		ReceiptEmail:     text(res.ReceiptEmail),
+		Livemode:         res.Livemode,
+		Created:          int64(res.Created),
+	}
+}
+
+func capturedIntent(res *types.CaptureIntentRes) *paymentspb.PaymentIntent {
+	return &paymentspb.PaymentIntent{
+		Id:               res.ID,
+		Amount:           int64(res.Amount),
+		AmountCapturable: int64(res.AmountCapturable),
+		AmountReceived:   int64(res.AmountReceived),
+		Currency:         res.Currency,
+		Status:           res.Status,
+		CaptureMethod:    res.CaptureMethod,
+		ClientSecret:     text(res.ClientSecret),
+		Description:      text(res.Description),
+		ReceiptEmail:     text(res.ReceiptEmail),
+		Livemode:         res.Livemode,
+		Created:          int64(res.Created),
+	}
+}
+
+func paymentIntent(intent *types.PaymentIntent) *paymentspb.PaymentIntent {
+	return &paymentspb.PaymentIntent{
+		Id:               intent.ID,
+		Amount:           int64(intent.Amount),
+		AmountCapturable: int64(intent.AmountCapturable),
+		AmountReceived:   int64(intent.AmountReceived),
+		Currency:         intent.Currency,
+		Status:           intent.Status,
+		CaptureMethod:    intent.CaptureMethod,
+		ClientSecret:     text(intent.ClientSecret),
+		Description:      text(intent.Description),
+		ReceiptEmail:     text(intent.ReceiptEmail),
+		Livemode:         intent.Livemode,
+		Created:          int64(intent.Created),
+	}
+}
+
+func refund(res *types.CreateRefundRes, paymentIntentID string) *paymentspb.Refund {
+	return &paymentspb.Refund{
+		Id:            res.ID,
+		PaymentIntent: paymentIntentID,
+		Amount:        int64(res.Amount),
+		Currency:      res.Currency,
+		Status:        res.Status,
+		Created:       int64(res.Created),
+	}
+}
+
+// text returns the string held by the untyped fields of the domain types, empty when unset.
+func text(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case *string:
+		if s != nil {
+			return *s
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,430 @@
+package grpc_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	paymentsGRPC "github.com/swagftw/stripe_pay_service/transport/grpc"
+	"github.com/swagftw/stripe_pay_service/transport/grpc/paymentspb"
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/ratelimit"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+// scopedAuth grants the scopes listed for each api key, other keys are refused.
+type scopedAuth map[string][]string
+
+func (a scopedAuth) Authenticate(_ context.Context, apiKey string) (*types.Principal, error) {
+	scopes, ok := a[apiKey]
+	if !ok {
+		return nil, fault.ErrUnauthorized.New("auth", "provide a valid api key", errors.New("unknown api key"))
+	}
+
+	return &types.Principal{ClientID: "client_1", MerchantID: "client_1", KeyID: apiKey, Scopes: scopes}, nil
+}
+
+// stubPayments answers from the funcs set, and records the principal and request id of the last call.
+type stubPayments struct {
+	capture func(id string) (*types.CaptureIntentRes, error)
+
+	principal *types.Principal
+	requestID string
+}
+
+func (s *stubPayments) record(ctx context.Context) {
+	s.principal, _ = types.PrincipalFromContext(ctx)
+	s.requestID, _ = types.RequestIDFromContext(ctx)
+}
+
+func (s *stubPayments) CreatePaymentIntent(ctx context.Context, req *types.CreateIntentReq) (*types.CreateIntentRes, error) {
+	s.record(ctx)
+
+	return &types.CreateIntentRes{ID: "pi_1", Amount: int(req.Amount), Currency: "inr", Status: "requires_payment_method", ReceiptEmail: req.Email}, nil
+}
+
+func (s *stubPayments) CapturePaymentIntent(ctx context.Context, id string) (*types.CaptureIntentRes, error) {
+	s.record(ctx)
+
+	return s.capture(id)
+}
+
+func (s *stubPayments) GetPaymentIntents(ctx context.Context) (*types.GetIntentsRes, error) {
+	s.record(ctx)
+
+	return &types.GetIntentsRes{Intents: []*types.PaymentIntent{{ID: "pi_1"}, {ID: "pi_2"}}}, nil
+}
+
+func (s *stubPayments) GetPaymentIntent(ctx context.Context, id string) (*types.PaymentIntent, error) {
+	s.record(ctx)
+
+	return &types.PaymentIntent{ID: id, Amount: 5000, Status: "succeeded", Description: "order 1"}, nil
+}
+
+func (s *stubPayments) CreateRefund(ctx context.Context, id string) (*types.CreateRefundRes, error) {
+	s.record(ctx)
+
+	return &types.CreateRefundRes{ID: "re_1", Amount: 5000, Status: "succeeded"}, nil
+}
+
+func newClient(t *testing.T, service types.PaymentService, limiter *server.RateLimiter) paymentspb.PaymentServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+
+	srv := paymentsGRPC.NewServer(service, scopedAuth{
+		"psk_reader":  {types.ScopePaymentsRead},
+		"psk_creator": {types.ScopePaymentsRead, types.ScopePaymentsWrite, types.ScopePaymentsCapture, types.ScopeRefundsWrite},
+	}, nil, limiter)
+
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return paymentspb.NewPaymentServiceClient(conn)
+}
+
+func withKey(apiKey string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+apiKey)
+}
+
+func errorInfo(t *testing.T, st *status.Status) *errdetails.ErrorInfo {
+	t.Helper()
+
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+
+	t.Fatalf("status %v has no error info", st)
+
+	return nil
+}
+
+func TestAuth(t *testing.T) {
+	logger.InitLogger()
+
+	client := newClient(t, &stubPayments{}, nil)
+
+	cases := []struct {
+		name       string
+		ctx        context.Context
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{name: "no credentials", ctx: context.Background(), wantCode: codes.Unauthenticated, wantReason: fault.ErrUnauthorized.Code},
+		{name: "unknown api key", ctx: withKey("psk_unknown"), wantCode: codes.Unauthenticated, wantReason: fault.ErrUnauthorized.Code},
+		{name: "missing scope", ctx: withKey("psk_reader"), wantCode: codes.PermissionDenied, wantReason: fault.ErrForbidden.Code},
+		{name: "granted scope", ctx: withKey("psk_creator"), wantCode: codes.OK},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.CreateRefund(tt.ctx, &paymentspb.CreateRefundRequest{Id: "pi_1"})
+
+			st := status.Convert(err)
+			assert.Equal(t, tt.wantCode, st.Code(), st.Message())
+
+			if tt.wantReason != "" {
+				assert.Equal(t, tt.wantReason, errorInfo(t, st).Reason)
+			}
+		})
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	logger.InitLogger()
+
+	cases := []struct {
+		name         string
+		err          error
+		wantCode     codes.Code
+		wantReason   string
+		wantMetadata map[string]string
+	}{
+		{
+			name:       "not found",
+			err:        fault.ErrPaymentNotFound.New("payment_repo", "check the payment id", errors.New("record not found")),
+			wantCode:   codes.NotFound,
+			wantReason: fault.ErrPaymentNotFound.Code,
+			wantMetadata: map[string]string{
+				"resolution": "check the payment id",
+				"retryable":  "false",
+			},
+		},
+		{
+			name: "card declined",
+			err: func() error {
+				err := fault.ErrCardDeclined.New("stripeclient", "ask the customer for another payment method", errors.New("issuer said no")).(*fault.HTTPError)
+				err.Details = map[string]string{"declineCode": "insufficient_funds"}
+
+				return err
+			}(),
+			wantCode:   codes.FailedPrecondition,
+			wantReason: fault.ErrCardDeclined.Code,
+			wantMetadata: map[string]string{
+				"resolution":  "ask the customer for another payment method",
+				"retryable":   "false",
+				"declineCode": "insufficient_funds",
+			},
+		},
+		{
+			name:       "idempotency key reused",
+			err:        fault.ErrIdempotencyConflict.New("stripeclient", "send the request with a new idempotency key", errors.New("idempotency_error")),
+			wantCode:   codes.FailedPrecondition,
+			wantReason: fault.ErrIdempotencyConflict.Code,
+		},
+		{
+			name:       "concurrent update",
+			err:        fault.ErrConcurrentUpdate.New("stripeclient", "retry the request", errors.New("lock_timeout")),
+			wantCode:   codes.Aborted,
+			wantReason: fault.ErrConcurrentUpdate.Code,
+		},
+		{
+			name:       "stripe unavailable",
+			err:        fault.ErrStripeUnavailable.New("stripeclient", "retry the request later", errors.New("connection refused")),
+			wantCode:   codes.Unavailable,
+			wantReason: fault.ErrStripeUnavailable.Code,
+			wantMetadata: map[string]string{
+				"resolution": "retry the request later",
+				"retryable":  "true",
+			},
+		},
+		{
+			name:       "error outside the catalog",
+			err:        errors.New("pq: connection reset by peer"),
+			wantCode:   codes.Internal,
+			wantReason: fault.ErrInternal.Code,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newClient(t, &stubPayments{
+				capture: func(string) (*types.CaptureIntentRes, error) {
+					return nil, tt.err
+				},
+			}, nil)
+
+			_, err := client.CapturePaymentIntent(withKey("psk_creator"), &paymentspb.CapturePaymentIntentRequest{Id: "pi_1"})
+
+			st := status.Convert(err)
+			assert.Equal(t, tt.wantCode, st.Code())
+			assert.NotContains(t, st.Message(), tt.err.Error(), "the underlying error is not sent")
+
+			info := errorInfo(t, st)
+			assert.Equal(t, tt.wantReason, info.Reason)
+
+			if tt.wantMetadata != nil {
+				assert.Equal(t, tt.wantMetadata, info.Metadata)
+			}
+		})
+	}
+}
+
+func TestCreatePaymentIntentValidation(t *testing.T) {
+	logger.InitLogger()
+
+	service := &stubPayments{}
+	client := newClient(t, service, nil)
+
+	_, err := client.CreatePaymentIntent(withKey("psk_creator"), &paymentspb.CreatePaymentIntentRequest{
+		Amount:   10,
+		Currency: "xyz",
+		Email:    "jane@example.com",
+	})
+
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, fault.ErrValidation.Code, errorInfo(t, st).Reason)
+
+	var fields []string
+
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.FieldViolations {
+				fields = append(fields, violation.Field)
+			}
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"amount", "currency"}, fields)
+	assert.Nil(t, service.principal, "invalid requests do not reach the service")
+
+	intent, err := client.CreatePaymentIntent(withKey("psk_creator"), &paymentspb.CreatePaymentIntentRequest{
+		Amount: 5000,
+		Email:  "jane@example.com",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "pi_1", intent.Id)
+	assert.Equal(t, int64(5000), intent.Amount)
+	assert.Equal(t, "jane@example.com", intent.ReceiptEmail)
+	assert.Equal(t, "psk_creator", service.principal.KeyID)
+}
+
+func TestCapturePaymentIntent(t *testing.T) {
+	logger.InitLogger()
+
+	client := newClient(t, &stubPayments{
+		capture: func(id string) (*types.CaptureIntentRes, error) {
+			return &types.CaptureIntentRes{
+				ID:           id,
+				Amount:       5000,
+				Status:       "succeeded",
+				ClientSecret: "pi_1_secret",
+				Description:  "order 1",
+				ReceiptEmail: "jane@example.com",
+			}, nil
+		},
+	}, nil)
+
+	intent, err := client.CapturePaymentIntent(withKey("psk_creator"), &paymentspb.CapturePaymentIntentRequest{Id: "pi_1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "pi_1", intent.Id)
+	assert.Equal(t, int64(5000), intent.Amount)
+	assert.Equal(t, "succeeded", intent.Status)
+	assert.Equal(t, "pi_1_secret", intent.ClientSecret)
+	assert.Equal(t, "order 1", intent.Description)
+	assert.Equal(t, "jane@example.com", intent.ReceiptEmail)
+}
+
+func TestRequestID(t *testing.T) {
+	logger.InitLogger()
+
+	service := &stubPayments{}
+	client := newClient(t, service, nil)
+
+	cases := []struct {
+		name      string
+		requestID string
+		wantKept  bool
+	}{
+		{name: "id of the caller", requestID: "req-123", wantKept: true},
+		{name: "no id", requestID: ""},
+		{name: "id with spaces", requestID: "req 123"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := withKey("psk_reader")
+			if tt.requestID != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", tt.requestID)
+			}
+
+			var header metadata.MD
+
+			intent, err := client.GetPaymentIntent(ctx, &paymentspb.GetPaymentIntentRequest{Id: "pi_1"}, grpc.Header(&header))
+			require.NoError(t, err)
+			assert.Equal(t, "order 1", intent.Description)
+
+			require.Len(t, header.Get("x-request-id"), 1)
+			requestID := header.Get("x-request-id")[0]
+
+			assert.Equal(t, service.requestID, requestID)
+
+			if tt.wantKept {
+				assert.Equal(t, tt.requestID, requestID)
+			} else {
+				assert.NotEmpty(t, requestID)
+				assert.NotEqual(t, tt.requestID, requestID)
+			}
+		})
+	}
+
+	t.Run("sent with errors", func(t *testing.T) {
+		var header metadata.MD
+
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-456")
+
+		_, err := client.ListPaymentIntents(ctx, &paymentspb.ListPaymentIntentsRequest{}, grpc.Header(&header))
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.Equal(t, []string{"req-456"}, header.Get("x-request-id"))
+	})
+}
+
+func TestRecovery(t *testing.T) {
+	logger.InitLogger()
+
+	client := newClient(t, &stubPayments{
+		capture: func(string) (*types.CaptureIntentRes, error) {
+			panic("nil map write")
+		},
+	}, nil)
+
+	_, err := client.CapturePaymentIntent(withKey("psk_creator"), &paymentspb.CapturePaymentIntentRequest{Id: "pi_1"})
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.NotContains(t, st.Message(), "nil map write", "the panic is not sent")
+	assert.Equal(t, fault.ErrInternal.Code, errorInfo(t, st).Reason)
+
+	// the server keeps serving
+	_, err = client.GetPaymentIntent(withKey("psk_reader"), &paymentspb.GetPaymentIntentRequest{Id: "pi_1"})
+	assert.NoError(t, err)
+}
+
+func TestRateLimit(t *testing.T) {
+	logger.InitLogger()
+
+	limiter := server.NewRateLimiter(&config.RateLimit{
+		Groups: map[string]config.RateLimitRule{
+			// refill too slowly to matter during the test
+			"auth":     {Rate: 0.001, Burst: 4},
+			"captures": {Rate: 0.001, Burst: 1},
+		},
+	}, ratelimit.NewMemoryStore())
+
+	client := newClient(t, &stubPayments{
+		capture: func(id string) (*types.CaptureIntentRes, error) {
+			return &types.CaptureIntentRes{ID: id, Status: "succeeded"}, nil
+		},
+	}, limiter)
+
+	capture := func(apiKey string) *status.Status {
+		_, err := client.CapturePaymentIntent(withKey(apiKey), &paymentspb.CapturePaymentIntentRequest{Id: "pi_1"})
+
+		return status.Convert(err)
+	}
+
+	assert.Equal(t, codes.OK, capture("psk_creator").Code())
+
+	// the second capture is over the limit of the captures group
+	st := capture("psk_creator")
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Equal(t, fault.ErrRateLimited.Code, errorInfo(t, st).Reason)
+
+	// the ip is limited before the credentials are checked
+	assert.Equal(t, codes.Unauthenticated, capture("psk_guess1").Code())
+	assert.Equal(t, codes.Unauthenticated, capture("psk_guess2").Code())
+	assert.Equal(t, codes.ResourceExhausted, capture("psk_guess3").Code())
+}
@@ -0,0 +1,234 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/constant"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+// metadata keys read from and sent to callers.
+const (
+	authorizationKey = "authorization"
+	requestIDKey     = "x-request-id"
+)
+
+// errorDomain names the service in the error info of failed calls.
+const errorDomain = "stripe-pay-service"
+
+// requestIDInterceptor puts the request id on the context and sends it back in the x-request-id header,
+// the id sent by the caller is kept when it is sane, otherwise a new one is generated.
+func requestIDInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	requestID := server.RequestIDOrNew(firstValue(ctx, requestIDKey))
+
+	// the header is sent with the status when the call fails before writing a response
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, requestID))
+
+	return handler(types.WithRequestID(ctx, requestID), req)
+}
+
+// errorInterceptor answers errors with the grpc code matching their status and the code of the fault catalog
+// in the error info, along with the resolution and details. server errors are logged instead of sent.
+func errorInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	res, err := handler(ctx, req)
+	if err == nil {
+		return res, nil
+	}
+
+	// errors of the grpc runtime already carry their status
+	if _, ok := status.FromError(err); ok {
+		return nil, err
+	}
+
+	var httpErr *fault.HTTPError
+	if !errors.As(err, &httpErr) {
+		httpErr = fault.ErrInternal.New("grpc", constant.TryAgainLater, err).(*fault.HTTPError)
+	}
+
+	if httpErr.Status >= http.StatusInternalServerError {
+		logger.Logger.Error(ctx, "error handling call", err, httpErr.ErrCode)
+	}
+
+	return nil, toStatus(httpErr).Err()
+}
+
+// recoveryInterceptor answers a call panicking with an internal error, the panic and its stack are logged
+// by the error interceptor.
+func recoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fault.ErrInternal.New("grpc", constant.TryAgainLater, fmt.Errorf("panic in %s: %v\n%s", info.FullMethod, r, debug.Stack()))
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+// rateLimitInterceptor takes a token from the rate limit group of the call for the caller key returns,
+// calls over the limit are refused.
+func rateLimitInterceptor(limiter *server.RateLimiter, key func(ctx context.Context, method string) (group, caller string)) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		group, caller := key(ctx, info.FullMethod)
+
+		_, err := limiter.Take(ctx, group, caller)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// authInterceptor authenticates calls with the bearer token of the authorization metadata and puts the principal
+// on the context. tokens of the identity provider are verified when verifier is set, other tokens are api keys.
+func authInterceptor(authService types.AuthService, verifier *server.JWTVerifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		token, err := bearerToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var principal *types.Principal
+
+		if verifier != nil && !server.IsAPIKey(token) {
+			principal, err = verifier.Verify(ctx, token)
+		} else {
+			principal, err = authService.Authenticate(ctx, token)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		scope, ok := methodScopes[info.FullMethod]
+		if !ok || !principal.HasScope(scope) {
+			return nil, fault.ErrForbidden.New("auth", "credentials are missing the "+scope+" scope", errors.New("missing scope "+scope+" for "+info.FullMethod))
+		}
+
+		return handler(types.WithPrincipal(ctx, principal), req)
+	}
+}
+
+// toStatus returns the grpc status of an error of the fault catalog.
+func toStatus(httpErr *fault.HTTPError) *status.Status {
+	code := httpErr.ErrCode
+	if code == "" {
+		code = fault.ForStatus(httpErr.Status).Code
+	}
+
+	info := &errdetails.ErrorInfo{
+		Reason: code,
+		Domain: errorDomain,
+		Metadata: map[string]string{
+			"resolution": httpErr.Res,
+			"retryable":  strconv.FormatBool(httpErr.Retryable),
+		},
+	}
+
+	for key, value := range httpErr.Details {
+		info.Metadata[key] = value
+	}
+
+	st := status.New(grpcCode(httpErr), httpErr.Message)
+
+	if len(httpErr.Fields) == 0 {
+		withDetails, err := st.WithDetails(info)
+		if err != nil {
+			return st
+		}
+
+		return withDetails
+	}
+
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(httpErr.Fields))
+	for _, field := range httpErr.Fields {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Message})
+	}
+
+	withDetails, err := st.WithDetails(info, &errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st
+	}
+
+	return withDetails
+}
+
+// grpcCode maps the http status of an error to the grpc code with the same meaning,
+// conflicts worth retrying abort the call while the others are failed preconditions.
+func grpcCode(httpErr *fault.HTTPError) codes.Code {
+	switch httpErr.Status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusPaymentRequired:
+		return codes.FailedPrecondition
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		if httpErr.Retryable {
+			return codes.Aborted
+		}
+
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+
+	return codes.Internal
+}
+
+// bearerToken returns the token from an "authorization: Bearer <token>" metadata entry.
+func bearerToken(ctx context.Context) (string, error) {
+	scheme, token, found := strings.Cut(firstValue(ctx, authorizationKey), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", fault.ErrUnauthorized.New("auth", "provide a valid api key in the authorization metadata", errors.New("missing bearer token"))
+	}
+
+	return strings.TrimSpace(token), nil
+}
+
+// peerIP returns the ip the call came from.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+func firstValue(ctx context.Context, key string) string {
+	values := metadata.ValueFromIncomingContext(ctx, key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
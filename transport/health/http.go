@@ -0,0 +1,68 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/swagftw/stripe_pay_service/utl/health"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+// probe paths, served outside /api/v1 for kubernetes and load balancers.
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+type HTTP struct {
+	checker *health.Checker
+}
+
+// Liveness tells the process is up.
+type Liveness struct {
+	Status string `json:"status"`
+}
+
+// InitHTTPHandlers initializes the liveness and readiness probes
+// readiness runs the dependency checks of checker and answers 503 when one fails,
+// the errors of failed checks are logged and only sent in debug mode as the probes are public.
+func InitHTTPHandlers(e *echo.Echo, checker *health.Checker) {
+	handler := &HTTP{checker: checker}
+
+	e.GET(LivenessPath, handler.liveness)
+
+	e.GET(ReadinessPath, handler.readiness)
+}
+
+// liveness never checks dependencies, a database outage must not get the process restarted.
+func (h HTTP) liveness(c echo.Context) error {
+	return c.JSON(http.StatusOK, Liveness{Status: health.StatusOK})
+}
+
+func (h HTTP) readiness(c echo.Context) error {
+	ctx := server.ToGoContext(c)
+	report := h.checker.Run(ctx)
+
+	for name, result := range report.Checks {
+		if result.Status == health.StatusOK {
+			continue
+		}
+
+		logger.Logger.Error(ctx, "readiness check failed", errors.New(result.Error), name)
+
+		if !server.DebugMode() {
+			result.Error = ""
+			report.Checks[name] = result
+		}
+	}
+
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	return c.JSON(status, report)
+}
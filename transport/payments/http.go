@@ -9,23 +9,35 @@ import (
 	"github.com/swagftw/stripe_pay_service/utl/server"
 )
 
+// routes of the payments group, relative to /api/v1.
+const (
+	GroupPath         = "/payments"
+	CreateIntentPath  = "/create_intent"
+	CaptureIntentPath = "/capture_intent/:id"
+	GetIntentsPath    = "/get_intents"
+	CreateRefundPath  = "/create_refund/:id"
+)
+
 type HTTP struct {
 	service types.PaymentService
 }
 
 // InitHTTPHandlers initializes HTTP handlers for payments service
-func InitHTTPHandlers(service types.PaymentService, v1 *echo.Group) {
+// authn authenticates every payments route, each route then checks the scope it needs.
+// limiter limits every ip under the auth group before authn runs, then every caller
+// for reads and intent creation under the payments group, captures and refunds are expensive and get groups of their own.
+func InitHTTPHandlers(service types.PaymentService, v1 *echo.Group, authn echo.MiddlewareFunc, limiter *server.RateLimiter) {
 	handler := &HTTP{service: service}
 
-	paymentGroup := v1.Group("/payments")
+	paymentGroup := v1.Group(GroupPath, limiter.LimitIP("auth"), authn)
 
-	paymentGroup.POST("/create_intent", handler.createPaymentIntent)
+	paymentGroup.POST(CreateIntentPath, handler.createPaymentIntent, server.RequireScope(types.ScopePaymentsWrite), limiter.Limit("payments"))
 
-	paymentGroup.POST("/capture_intent/:id", handler.capturePaymentIntent)
+	paymentGroup.POST(CaptureIntentPath, handler.capturePaymentIntent, server.RequireScope(types.ScopePaymentsCapture), limiter.Limit("captures"))
 
-	paymentGroup.GET("/get_intents", handler.getPaymentIntents)
+	paymentGroup.GET(GetIntentsPath, handler.getPaymentIntents, server.RequireScope(types.ScopePaymentsRead), limiter.Limit("payments"))
 
-	paymentGroup.POST("/create_refund/:id", handler.refundPaymentIntent)
+	paymentGroup.POST(CreateRefundPath, handler.refundPaymentIntent, server.RequireScope(types.ScopeRefundsWrite), limiter.Limit("refunds"))
 }
 
 func (h HTTP) createPaymentIntent(c echo.Context) error {
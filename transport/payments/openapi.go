@@ -0,0 +1,129 @@
+package payments
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/openapi"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+// OpenAPI returns the openapi document of the payments routes. the schemas are generated from the request
+// and response types, so the document follows them, the routes are checked against InitHTTPHandlers in tests.
+func OpenAPI() *openapi.Document {
+	g := openapi.NewGenerator()
+
+	g.Rule("currency", func(s *openapi.Schema) {
+		s.Pattern = "^[A-Za-z]{3}$"
+		s.Description = "ISO 4217 currency code, inr when empty."
+	})
+	g.Rule("amount", func(s *openapi.Schema) {
+		minimum, maximum := float64(server.MinAmount), float64(server.MaxAmount)
+		s.Minimum, s.Maximum = &minimum, &maximum
+		s.Description = "Amount in the smallest currency unit."
+	})
+
+	errorResponse := errorResponses(g)
+
+	idParam := openapi.Parameter{
+		Name:        "id",
+		In:          "path",
+		Description: "Id of the stripe payment intent.",
+		Required:    true,
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+
+	return &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "Stripe Pay Service",
+			Description: "Payment intents, captures and refunds on stripe.",
+			Version:     "1.0.0",
+		},
+		Servers: []openapi.Server{{URL: "/api/v1"}},
+		Paths: map[string]openapi.PathItem{
+			openapi.Path(GroupPath + CreateIntentPath): {
+				"post": {
+					OperationID: "createPaymentIntent",
+					Summary:     "Create a payment intent",
+					Description: "Creates a payment intent, captured later. Requires the `" + types.ScopePaymentsWrite + "` scope.",
+					Tags:        []string{"payments"},
+					RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(g.Schema(types.CreateIntentReq{}))},
+					Responses: errorResponse(map[string]*openapi.Response{
+						"201": {Description: "The payment intent.", Content: openapi.JSON(g.Schema(types.CreateIntentRes{}))},
+					}, http.StatusBadRequest, http.StatusPaymentRequired, http.StatusUnprocessableEntity),
+				},
+			},
+			openapi.Path(GroupPath + CaptureIntentPath): {
+				"post": {
+					OperationID: "capturePaymentIntent",
+					Summary:     "Capture a payment intent",
+					Description: "Confirms the payment intent and captures its amount. Requires the `" + types.ScopePaymentsCapture + "` scope.",
+					Tags:        []string{"payments"},
+					Parameters:  []openapi.Parameter{idParam},
+					Responses: errorResponse(map[string]*openapi.Response{
+						"200": {Description: "The captured payment intent.", Content: openapi.JSON(g.Schema(types.CaptureIntentRes{}))},
+					}, http.StatusBadRequest, http.StatusPaymentRequired, http.StatusNotFound, http.StatusConflict),
+				},
+			},
+			openapi.Path(GroupPath + GetIntentsPath): {
+				"get": {
+					OperationID: "getPaymentIntents",
+					Summary:     "List payment intents",
+					Description: "Lists the latest payment intents. Requires the `" + types.ScopePaymentsRead + "` scope.",
+					Tags:        []string{"payments"},
+					Responses: errorResponse(map[string]*openapi.Response{
+						"200": {Description: "The payment intents.", Content: openapi.JSON(g.Schema(types.GetIntentsRes{}))},
+					}),
+				},
+			},
+			openapi.Path(GroupPath + CreateRefundPath): {
+				"post": {
+					OperationID: "createRefund",
+					Summary:     "Refund a payment intent",
+					Description: "Refunds the captured amount of the payment intent. Requires the `" + types.ScopeRefundsWrite + "` scope.",
+					Tags:        []string{"payments"},
+					Parameters:  []openapi.Parameter{idParam},
+					Responses: errorResponse(map[string]*openapi.Response{
+						"201": {Description: "The refund.", Content: openapi.JSON(g.Schema(types.CreateRefundRes{}))},
+					}, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict),
+				},
+			},
+		},
+		Components: openapi.Components{
+			Schemas: g.Schemas(),
+			SecuritySchemes: map[string]openapi.SecurityScheme{
+				"bearerAuth": {
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: "An api key, or a token of the identity provider when jwt is enabled.",
+				},
+			},
+		},
+		Security: []map[string][]string{{"bearerAuth": {}}},
+	}
+}
+
+// errorResponses returns a func adding the error responses of statuses to the responses of an operation,
+// the statuses any route may answer are always added. errors are in the envelope or problem documents.
+func errorResponses(g *openapi.Generator) func(responses map[string]*openapi.Response, statuses ...int) map[string]*openapi.Response {
+	content := map[string]openapi.MediaType{
+		"application/json":    {Schema: g.Schema(fault.Envelope{})},
+		fault.MIMEProblemJSON: {Schema: g.Schema(fault.Problem{})},
+	}
+
+	common := []int{
+		http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+	}
+
+	return func(responses map[string]*openapi.Response, statuses ...int) map[string]*openapi.Response {
+		for _, status := range append(statuses, common...) {
+			responses[strconv.Itoa(status)] = &openapi.Response{Description: http.StatusText(status), Content: content}
+		}
+
+		return responses
+	}
+}
@@ -0,0 +1,82 @@
+package payments_test
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swagftw/stripe_pay_service/transport/payments"
+	"github.com/swagftw/stripe_pay_service/utl/openapi"
+)
+
+// specFile is the checked in openapi document, rewritten by go test ./transport/payments/... -update.
+const specFile = "../../../docs/openapi.json"
+
+var update = flag.Bool("update", false, "rewrite "+specFile+" from the go types")
+
+func TestOpenAPIUpToDate(t *testing.T) {
+	spec, err := json.MarshalIndent(payments.OpenAPI(), "", "  ")
+	require.NoError(t, err)
+
+	spec = append(spec, '\n')
+
+	if *update {
+		require.NoError(t, os.WriteFile(specFile, spec, 0o644))
+	}
+
+	checkedIn, err := os.ReadFile(specFile)
+	require.NoError(t, err)
+
+	assert.Equal(t, string(checkedIn), string(spec), "the api changed, run go test ./transport/payments/... -update")
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	e := echo.New()
+	passthrough := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+
+	payments.InitHTTPHandlers(nil, e.Group("/api/v1"), passthrough, nil)
+
+	doc := payments.OpenAPI()
+
+	documented := 0
+	for _, item := range doc.Paths {
+		documented += len(item)
+	}
+
+	routes := 0
+
+	for _, route := range e.Routes() {
+		path := strings.TrimPrefix(route.Path, "/api/v1")
+		// echo routes the unmatched paths of groups with middleware to not found handlers
+		if !strings.HasPrefix(path, payments.GroupPath+"/") || strings.HasSuffix(path, "/*") {
+			continue
+		}
+
+		routes++
+
+		operation := doc.Paths[openapi.Path(path)][strings.ToLower(route.Method)]
+		if assert.NotNil(t, operation, "%s %s is not documented", route.Method, route.Path) {
+			assert.Contains(t, operation.Responses, "401")
+		}
+	}
+
+	assert.Equal(t, routes, documented, "every documented operation must be routed")
+
+	for _, name := range []string{"CreateIntentReq", "CreateIntentRes", "CaptureIntentRes", "GetIntentsRes", "PaymentIntent", "CreateRefundRes", "Envelope", "Problem"} {
+		assert.Contains(t, doc.Components.Schemas, name)
+	}
+
+	req := doc.Components.Schemas["CreateIntentReq"]
+	assert.Equal(t, []string{"amount", "email"}, req.Required)
+	assert.Equal(t, "email", req.Properties["email"].Format)
+	assert.Equal(t, float64(50), *req.Properties["amount"].Minimum)
+	assert.NotEmpty(t, req.Properties["phone"].Pattern)
+	assert.Equal(t, http.StatusText(http.StatusPaymentRequired), doc.Paths["/payments/create_intent"]["post"].Responses["402"].Description)
+}
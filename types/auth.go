@@ -0,0 +1,68 @@
+package types
+
+import (
+	"context"
+
+	"github.com/swagftw/stripe_pay_service/utl/constant"
+)
+
+// scopes granted to api clients.
+const (
+	ScopePaymentsRead    = "payments:read"
+	ScopePaymentsWrite   = "payments:write"
+	ScopePaymentsCapture = "payments:capture"
+	ScopeRefundsWrite    = "refunds:write"
+	// ScopeAdmin grants the operational endpoints, like changing the log level.
+	ScopeAdmin = "admin"
+)
+
+type (
+	// AuthService is the interface that wraps api client authentication.
+	AuthService interface {
+		Authenticate(ctx context.Context, apiKey string) (*Principal, error)
+	}
+
+	// Principal is the authenticated api client a request is made on behalf of.
+	Principal struct {
+		// ClientID is the merchant or client the credentials belong to.
+		ClientID string
+		// MerchantID is the merchant the request acts for, data access is scoped by it.
+		MerchantID string
+		// KeyID is the id of the credential used to authenticate.
+		KeyID  string
+		Scopes []string
+	}
+)
+
+// HasScope reports whether the principal was granted the given scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, constant.TxKey(constant.PrincipalKey), principal)
+}
+
+// PrincipalFromContext returns the authenticated principal stored on the context, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(constant.TxKey(constant.PrincipalKey)).(*Principal)
+
+	return principal, ok
+}
+
+// MerchantIDFromContext returns the merchant the authenticated principal acts for, if any.
+func MerchantIDFromContext(ctx context.Context) (string, bool) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.MerchantID == "" {
+		return "", false
+	}
+
+	return principal.MerchantID, true
+}
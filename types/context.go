@@ -0,0 +1,32 @@
+package types
+
+import (
+	"context"
+
+	"github.com/swagftw/stripe_pay_service/utl/constant"
+)
+
+// RequestIDFromContext returns the id of the request ctx was created for, if any.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(constant.TxKey(constant.RequestIDKey)).(string)
+
+	return requestID, ok && requestID != ""
+}
+
+// WithRequestID returns a copy of ctx carrying the id of the request it was created for.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, constant.TxKey(constant.RequestIDKey), requestID)
+}
+
+// WithPaymentID returns a copy of ctx carrying the id of the payment intent being worked on,
+// so log lines written with it can be correlated.
+func WithPaymentID(ctx context.Context, paymentID string) context.Context {
+	return context.WithValue(ctx, constant.TxKey(constant.PaymentIDKey), paymentID)
+}
+
+// PaymentIDFromContext returns the id of the payment intent being worked on, if any.
+func PaymentIDFromContext(ctx context.Context) (string, bool) {
+	paymentID, ok := ctx.Value(constant.TxKey(constant.PaymentIDKey)).(string)
+
+	return paymentID, ok && paymentID != ""
+}
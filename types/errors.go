@@ -5,3 +5,6 @@ import "errors"
 type CopyError error
 
 var ErrCopyingData CopyError = errors.New("error copying data")
+
+// ErrMerchantRequired is returned when merchant scoped data is accessed without an authenticated merchant.
+var ErrMerchantRequired = errors.New("request is not scoped to a merchant")
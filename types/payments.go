@@ -15,6 +15,7 @@ type (
 		CreatePaymentIntent(ctx context.Context, intent *CreateIntentReq) (*CreateIntentRes, error)
 		CapturePaymentIntent(ctx context.Context, id string) (*CaptureIntentRes, error)
 		GetPaymentIntents(ctx context.Context) (*GetIntentsRes, error)
+		GetPaymentIntent(ctx context.Context, id string) (*PaymentIntent, error)
 		CreateRefund(ctx context.Context, id string) (*CreateRefundRes, error)
 	}
 
@@ -71,9 +72,12 @@ type (
 	}
 
 	CreateIntentReq struct {
-		Amount      int64  `json:"amount" validate:"required"`
-		Email       string `json:"email" validate:"required"`
-		Phone       string `json:"phone" `
+		// Amount is in the smallest unit of the currency.
+		Amount int64 `json:"amount" validate:"required,amount"`
+		// Currency is a three letter iso 4217 code, inr when empty.
+		Currency    string `json:"currency" validate:"omitempty,currency"`
+		Email       string `json:"email" validate:"required,email"`
+		Phone       string `json:"phone" validate:"omitempty,e164"`
 		Description string `json:"description"`
 	}
 
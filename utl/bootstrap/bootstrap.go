@@ -0,0 +1,45 @@
+package bootstrap
+
+import (
+	"context"
+
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/redact"
+	"github.com/swagftw/stripe_pay_service/utl/secrets"
+)
+
+// Init prepares a command to run: it loads the config from cfgPath and ./.env with the overrides applied,
+// configures log redaction and the logger from it and fetches the secrets.
+// errors are logged before they are returned, so callers only need to exit.
+func Init(ctx context.Context, cfgPath string, overrides ...string) error {
+	// init log
+	logger.InitLogger()
+
+	// init config
+	err := config.InitConfig(cfgPath, "./.env", overrides...)
+	if err != nil {
+		return err
+	}
+
+	// mask secrets and PII in logs and stored payloads
+	redact.Configure(config.GetGlobalConfig().GetRedactionConfig().Paths)
+
+	// rebuild log from config
+	err = logger.Configure(config.GetGlobalConfig().GetLoggerOptions())
+	if err != nil {
+		logger.Logger.Error(ctx, "error configuring logger", err)
+
+		return err
+	}
+
+	// fetch the stripe key and database password from the secrets provider
+	err = secrets.Init(ctx)
+	if err != nil {
+		logger.Logger.Error(ctx, "error fetching secrets", err)
+
+		return err
+	}
+
+	return nil
+}
@@ -1,160 +1,375 @@
 package config
 
 import (
-	"context"
-	"io/ioutil"
 	"sync"
 
-	"github.com/joho/godotenv"
-	"github.com/spf13/viper"
-	"gopkg.in/yaml.v3"
-
 	"github.com/swagftw/stripe_pay_service/utl/logger"
 )
 
 var config *GlobalConfig
 
+// environments the service runs in, prod must use live stripe keys and the others test keys.
+const (
+	EnvironmentDev     = "dev"
+	EnvironmentStaging = "staging"
+	EnvironmentProd    = "prod"
+)
+
+// error formats of the api, envelope wraps errors in an error object and problem renders rfc 7807 documents.
+const (
+	ErrorFormatEnvelope = "envelope"
+	ErrorFormatProblem  = "problem"
+)
+
 type GlobalConfig struct {
-	Server Server `yaml:"server"`
-	DB     DB     `yaml:"database"`
-	Stripe Stripe `yaml:"stripeclient"`
-	mutex  sync.Mutex
+	Server    Server    `yaml:"server"`
+	DB        DB        `yaml:"database"`
+	Stripe    Stripe    `yaml:"stripe"`
+	JWT       JWT       `yaml:"jwt"`
+	Security  Security  `yaml:"security"`
+	RateLimit RateLimit `yaml:"rateLimit"`
+	Tracing   Tracing   `yaml:"tracing"`
+	Log       Log       `yaml:"log"`
+	Redaction Redaction `yaml:"redaction"`
+	Health    Health    `yaml:"health"`
+	Secrets   Secrets   `yaml:"secrets"`
+	GRPC      GRPC      `yaml:"grpc"`
+	mutex     sync.Mutex
 }
 
 type Server struct {
-	Port     string `yaml:"port"`
-	Debug    bool   `yaml:"debug"`
-	LogLevel string `yaml:"logLevel"`
-	Timeout  int    `yaml:"timeout"`
+	// Environment is dev, staging or prod.
+	Environment string `yaml:"environment" env:"ENVIRONMENT"`
+	Port        string `yaml:"port" env:"PORT"`
+	Debug       bool   `yaml:"debug" env:"DEBUG"`
+	LogLevel    string `yaml:"logLevel" env:"LOG_LEVEL"`
+	Timeout     int    `yaml:"timeout" env:"TIMEOUT"`
+	// ShutdownTimeout is the number of seconds in-flight requests are drained for on shutdown.
+	ShutdownTimeout int `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+	// AllowedOrigins lists the origins allowed to make cross origin requests, none when empty.
+	AllowedOrigins []string `yaml:"allowedOrigins" env:"ALLOWED_ORIGINS"`
+	// ErrorFormat is the format of errors for requests not asking for one in Accept, envelope or problem.
+	ErrorFormat string `yaml:"errorFormat" env:"ERROR_FORMAT"`
+	// DocsUI serves a page browsing the openapi document at /api/v1/docs.
+	DocsUI bool `yaml:"docsUI" env:"DOCS_UI"`
 }
 
 type DB struct {
-	User     string `yaml:"user"`
-	Password string `yaml:"pass"`
-	Host     string `yaml:"host"`
-	Port     string `yaml:"port"`
-	Database string `yaml:"db"`
-	Timeout  int    `yaml:"timeout"`
+	User     string `yaml:"user" env:"POSTGRES_USER"`
+	Password string `yaml:"password" env:"POSTGRES_PASSWORD"`
+	Host     string `yaml:"host" env:"POSTGRES_HOST"`
+	Port     string `yaml:"port" env:"POSTGRES_PORT"`
+	Database string `yaml:"db" env:"POSTGRES_DB"`
+	// Timeout is the number of seconds a connection attempt may take.
+	Timeout int `yaml:"timeout" env:"DB_TIMEOUT"`
+	// MaxOpenConns and MaxIdleConns bound the connection pool, zero leaves open connections unbounded
+	// and keeps the database/sql default of 2 idle ones.
+	MaxOpenConns int `yaml:"maxOpenConns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns int `yaml:"maxIdleConns" env:"DB_MAX_IDLE_CONNS"`
+	// ConnMaxLifetime is the number of seconds a connection is reused for, zero reuses it forever.
+	ConnMaxLifetime int `yaml:"connMaxLifetime"`
+	// StatementTimeout is the number of milliseconds a statement may run before postgres cancels it, zero never.
+	StatementTimeout int `yaml:"statementTimeout"`
+	// SSLMode is the libpq sslmode, disable, require, verify-ca or verify-full.
+	SSLMode string `yaml:"sslMode" env:"DB_SSL_MODE"`
+	// SSLRootCert is the path of the CA certificate the server certificate is verified with,
+	// SSLCert and SSLKey the paths of the client certificate and key.
+	SSLRootCert string `yaml:"sslRootCert" env:"DB_SSL_ROOT_CERT"`
+	SSLCert     string `yaml:"sslCert" env:"DB_SSL_CERT"`
+	SSLKey      string `yaml:"sslKey" env:"DB_SSL_KEY"`
+	// ApplicationName names the service in pg_stat_activity.
+	ApplicationName string `yaml:"applicationName"`
+	// ConnectRetries is how many times connecting is retried on start, with a backoff doubling from one second.
+	ConnectRetries int `yaml:"connectRetries"`
+	// Replicas are the connection strings of read replicas, lookups outside of transactions are spread over them.
+	Replicas []string `yaml:"replicas" env:"DB_REPLICAS"`
+	// MaxReplicaLag is the number of seconds a replica may lag behind the primary and still be read from.
+	MaxReplicaLag int `yaml:"maxReplicaLag"`
+	// AutoMigrate applies pending migrations when the server starts, otherwise they are applied with `api migrate up`.
+	AutoMigrate bool `yaml:"autoMigrate" env:"AUTO_MIGRATE"`
 }
 
 type Stripe struct {
-	SecretKey      string `yaml:"secretKey"`
-	PublishableKey string `yaml:"publishableKey"`
+	SecretKey      string `yaml:"secretKey" env:"STRIPE_SECRET_KEY"`
+	PublishableKey string `yaml:"publishableKey" env:"STRIPE_PUBLISHABLE_KEY"`
 }
 
-// InitConfig initializes the config.
-func InitConfig(path string, envPath string) error {
-	configFile, err := ioutil.ReadFile(path)
-	if err != nil {
-		logger.Logger.Error(context.TODO(), "config file not found", err)
+// JWT configures bearer token authentication for internal callers.
+type JWT struct {
+	Enabled bool `yaml:"enabled" env:"JWT_ENABLED"`
+	// JWKSURL is where the identity provider publishes its signing keys.
+	JWKSURL string `yaml:"jwksUrl" env:"JWT_JWKS_URL"`
+	// StaticKeys maps key ids to PEM encoded public keys, used alongside or instead of the JWKS URL.
+	StaticKeys map[string]string `yaml:"staticKeys"`
+	Issuer     string            `yaml:"issuer" env:"JWT_ISSUER"`
+	Audience   string            `yaml:"audience" env:"JWT_AUDIENCE"`
+	// Leeway is the clock skew in seconds tolerated when checking exp and nbf.
+	Leeway int `yaml:"leeway"`
+	// RoleClaim is the claim holding the caller roles.
+	RoleClaim string `yaml:"roleClaim"`
+	// Roles maps roles to the scopes they grant.
+	Roles map[string][]string `yaml:"roles"`
+}
 
-		return err
-	}
+// Security holds the keys used to protect data at rest.
+type Security struct {
+	// EncryptionKey is the base64 encoded 32 byte key merchant stripe keys are encrypted with.
+	EncryptionKey string `yaml:"encryptionKey" env:"ENCRYPTION_KEY"`
+}
 
-	// load env variables from file to environment
-	_ = godotenv.Load(envPath)
+// RateLimit configures request rate limiting per route group.
+type RateLimit struct {
+	// Store keeps the token buckets, "memory" limits each instance on its own
+	// while "postgres" shares the limits between all instances.
+	Store string `yaml:"store" env:"RATE_LIMIT_STORE"`
+	// Groups maps route groups to their limits, groups missing here are not limited.
+	// they are reloaded while running, so they are read through Rule.
+	Groups map[string]RateLimitRule `yaml:"groups"`
+	mutex  sync.RWMutex
+}
 
-	viper.AutomaticEnv()
+// Rule returns the limit of a route group, false when the group is not limited.
+func (r *RateLimit) Rule(group string) (RateLimitRule, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 
-	config = new(GlobalConfig)
-	config.mutex = sync.Mutex{}
+	rule, ok := r.Groups[group]
 
-	unmarshallErr := yaml.Unmarshal(configFile, config)
+	return rule, ok
+}
 
-	loadEnv()
+func (r *RateLimit) setGroups(groups map[string]RateLimitRule) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-	if unmarshallErr != nil {
-		logger.Logger.Error(context.TODO(), "failed to unmarshal config file", err)
-	}
+	r.Groups = groups
+}
 
-	return nil
+// RateLimitRule is a token bucket holding Burst requests and refilling at Rate requests per second.
+type RateLimitRule struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
 }
 
-func loadEnv() {
-	port := viper.GetString("PORT")
-	if port != "" {
-		config.Server.Port = port
-	}
+// Log configures the log output, the level is Server.LogLevel.
+type Log struct {
+	// Encoding is json or console.
+	Encoding string `yaml:"encoding" env:"LOG_ENCODING"`
+	// SamplingInitial and SamplingThereafter sample repeated log lines each second, zero disables sampling.
+	SamplingInitial    int      `yaml:"samplingInitial"`
+	SamplingThereafter int      `yaml:"samplingThereafter"`
+	OutputPaths        []string `yaml:"outputPaths"`
+	ErrorOutputPaths   []string `yaml:"errorOutputPaths"`
+}
 
-	debug := viper.GetBool("DEBUG")
-	config.Server.Debug = debug
+// Redaction configures what is masked in stored stripe payloads and in logs.
+type Redaction struct {
+	// Paths are the dot separated field paths masked, they replace redact.DefaultPaths when set.
+	Paths []string `yaml:"paths"`
+}
 
-	logLevel := viper.GetString("LOG_LEVEL")
-	if logLevel != "" {
-		config.Server.LogLevel = logLevel
-	}
+// Health configures the readiness checks.
+type Health struct {
+	// Timeout is the number of seconds each dependency check may take.
+	Timeout int `yaml:"timeout"`
+	// StripeCacheTTL is the number of seconds a stripe reachability check is reused for.
+	StripeCacheTTL int `yaml:"stripeCacheTTL"`
+}
 
-	timeout := viper.GetInt("TIMEOUT")
-	if timeout != 0 {
-		config.Server.Timeout = timeout
-	}
+// Secrets configures the provider the stripe secret key and the database password are fetched from,
+// they are taken from the config as they are when no provider is set.
+type Secrets struct {
+	// Provider is env, file or vault.
+	Provider string `yaml:"provider" env:"SECRETS_PROVIDER"`
+	// Dir is the directory the references of the file provider are relative to.
+	Dir string `yaml:"dir" env:"SECRETS_DIR"`
+	// RefreshInterval is the number of seconds between fetches picking up rotated secrets, zero fetches them once.
+	RefreshInterval int `yaml:"refreshInterval" env:"SECRETS_REFRESH_INTERVAL"`
+	// StripeSecretKey and DBPassword reference the secrets in the provider, such as stripe#secret_key in vault,
+	// the config value is kept when a reference is empty.
+	StripeSecretKey string `yaml:"stripeSecretKey" env:"SECRETS_STRIPE_SECRET_KEY"`
+	DBPassword      string `yaml:"dbPassword" env:"SECRETS_DB_PASSWORD"`
+	Vault           Vault  `yaml:"vault"`
+}
 
-	dbUser := viper.GetString("POSTGRES_USER")
-	if dbUser != "" {
-		config.DB.User = dbUser
-	}
+// Vault locates a HashiCorp Vault KV version 2 secrets engine.
+type Vault struct {
+	Address string `yaml:"address" env:"VAULT_ADDR"`
+	Token   string `yaml:"token" env:"VAULT_TOKEN"`
+	// Mount is the path the KV engine is mounted at, secret by default.
+	Mount     string `yaml:"mount" env:"VAULT_MOUNT"`
+	Namespace string `yaml:"namespace" env:"VAULT_NAMESPACE"`
+}
 
-	dbPassword := viper.GetString("POSTGRES_PASSWORD")
-	if dbPassword != "" {
-		config.DB.Password = dbPassword
-	}
+// GRPC configures the grpc transport, served next to the http server.
+type GRPC struct {
+	Enabled bool   `yaml:"enabled" env:"GRPC_ENABLED"`
+	Port    string `yaml:"port" env:"GRPC_PORT"`
+}
 
-	dbHost := viper.GetString("POSTGRES_HOST")
-	if dbHost != "" {
-		config.DB.Host = dbHost
-	}
+// Tracing configures OpenTelemetry tracing.
+type Tracing struct {
+	// Exporter is where spans are sent, "otlp", "stdout" or "none".
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	// Endpoint is the host:port of the OTLP gRPC collector.
+	Endpoint string `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+	// Insecure disables TLS towards the collector.
+	Insecure bool `yaml:"insecure"`
+	// SampleRatio is the share of new traces recorded, traces started by callers follow their decision.
+	SampleRatio float64 `yaml:"sampleRatio"`
+	ServiceName string  `yaml:"serviceName"`
+}
 
-	dbDatabase := viper.GetString("POSTGRES_DB")
-	if dbDatabase != "" {
-		config.DB.Database = dbDatabase
-	}
+// GetGlobalConfig returns the global config.
+func GetGlobalConfig() *GlobalConfig {
+	return config
+}
 
-	dbPort := viper.GetString("POSTGRES_PORT")
-	if dbDatabase != "" {
-		config.DB.Port = dbPort
-	}
+// GetServerConfig returns a copy of the Server config, the log level changes on reload.
+func (c *GlobalConfig) GetServerConfig() *Server {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	dbTimout := viper.GetInt("DB_TIMEOUT")
-	if dbTimout != 0 {
-		config.DB.Timeout = dbTimout
-	}
+	s := c.Server
+
+	return &s
+}
 
-	secretKey := viper.GetString("STRIPE_SECRET_KEY")
-	if secretKey != "" {
-		config.Stripe.SecretKey = secretKey
+// GetLoggerOptions returns the options to build the logger with.
+// debug mode logs at debug level unless a level is set, and logs development friendly.
+func (c *GlobalConfig) GetLoggerOptions() logger.Options {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	opts := logger.Options{
+		Level:              c.Server.LogLevel,
+		Encoding:           c.Log.Encoding,
+		Development:        c.Server.Debug,
+		SamplingInitial:    c.Log.SamplingInitial,
+		SamplingThereafter: c.Log.SamplingThereafter,
+		OutputPaths:        c.Log.OutputPaths,
+		ErrorOutputPaths:   c.Log.ErrorOutputPaths,
 	}
 
-	publishableKey := viper.GetString("STRIPE_PUBLISHABLE_KEY")
-	if publishableKey != "" {
-		config.Stripe.PublishableKey = publishableKey
+	if opts.Level == "" && c.Server.Debug {
+		opts.Level = "debug"
 	}
+
+	return opts
 }
 
-// GetGlobalConfig returns the global config.
-func GetGlobalConfig() *GlobalConfig {
-	return config
+// GetDBConfig returns a copy of the database config, the password changes when secrets rotate.
+func (c *GlobalConfig) GetDBConfig() *DB {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	d := c.DB
+
+	return &d
 }
 
-// GetServerConfig returns the Server config.
-func (c *GlobalConfig) GetServerConfig() *Server {
+// GetJWTConfig returns the JWT config.
+func (c *GlobalConfig) GetJWTConfig() *JWT {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	return &c.Server
+	return &c.JWT
 }
 
-// GetDBConfig returns the database config.
-func (c *GlobalConfig) GetDBConfig() *DB {
+// GetSecurityConfig returns the security config.
+func (c *GlobalConfig) GetSecurityConfig() *Security {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	return &c.DB
+	return &c.Security
 }
 
-// GetStripeConfig returns the Stripe config.
+// GetRateLimitConfig returns the rate limit config.
+func (c *GlobalConfig) GetRateLimitConfig() *RateLimit {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return &c.RateLimit
+}
+
+// GetTracingConfig returns the tracing config.
+func (c *GlobalConfig) GetTracingConfig() *Tracing {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return &c.Tracing
+}
+
+// GetGRPCConfig returns the grpc config.
+func (c *GlobalConfig) GetGRPCConfig() *GRPC {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return &c.GRPC
+}
+
+// GetRedactionConfig returns the redaction config.
+func (c *GlobalConfig) GetRedactionConfig() *Redaction {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return &c.Redaction
+}
+
+// GetHealthConfig returns the health config.
+func (c *GlobalConfig) GetHealthConfig() *Health {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return &c.Health
+}
+
+// GetSecretsConfig returns the secrets config.
+func (c *GlobalConfig) GetSecretsConfig() *Secrets {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return &c.Secrets
+}
+
+// GetDBPassword returns the database password, which changes when it is rotated.
+func (c *GlobalConfig) GetDBPassword() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.DB.Password
+}
+
+// SetDBPassword replaces the database password, new connections are made with it.
+func (c *GlobalConfig) SetDBPassword(password string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.DB.Password = password
+}
+
+// GetStripeSecretKey returns the stripe secret key, which changes when it is rotated.
+func (c *GlobalConfig) GetStripeSecretKey() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.Stripe.SecretKey
+}
+
+// SetStripeSecretKey replaces the stripe secret key, the stripe client is rebuilt with it on its next call.
+func (c *GlobalConfig) SetStripeSecretKey(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.Stripe.SecretKey = key
+}
+
+// GetStripeConfig returns a copy of the Stripe config, the secret key changes when secrets rotate.
 func (c *GlobalConfig) GetStripeConfig() *Stripe {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	return &c.Stripe
+	s := c.Stripe
+
+	return &s
 }
@@ -0,0 +1,277 @@
+package config_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+)
+
+const baseYAML = `
+server:
+  port: 8080
+  logLevel: info
+database:
+  user: postgres
+  host: localhost
+  db: payments
+rateLimit:
+  groups:
+    payments:
+      rate: 10
+      burst: 20
+security:
+  encryptionKey: AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestInitConfigLayers(t *testing.T) {
+	logger.InitLogger()
+
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", baseYAML)
+	secret := writeFile(t, dir, "db_password", "s3cret\n")
+
+	t.Setenv("PORT", " 9090")
+	t.Setenv("POSTGRES_PASSWORD_FILE", secret)
+	t.Setenv("ALLOWED_ORIGINS", "https://a.example, https://b.example")
+
+	err := config.InitConfig(path, "", "database.host=db.internal", "tracing.sampleRatio=0.5")
+	require.NoError(t, err)
+
+	cfg := config.GetGlobalConfig()
+
+	// the env wins over the file
+	assert.Equal(t, "9090", cfg.GetServerConfig().Port)
+	assert.Equal(t, []string{"https://a.example", "https://b.example"}, cfg.GetServerConfig().AllowedOrigins)
+	// secrets are read from files
+	assert.Equal(t, "s3cret", cfg.GetDBConfig().Password)
+	// overrides win over the file
+	assert.Equal(t, "db.internal", cfg.GetDBConfig().Host)
+	assert.Equal(t, 0.5, cfg.GetTracingConfig().SampleRatio)
+	// the defaults fill in the rest
+	assert.Equal(t, "5432", cfg.GetDBConfig().Port)
+	assert.Equal(t, "memory", cfg.GetRateLimitConfig().Store)
+}
+
+func TestInitConfigErrors(t *testing.T) {
+	logger.InitLogger()
+
+	cases := []struct {
+		name      string
+		yaml      string
+		env       map[string]string
+		overrides []string
+		errs      int
+		contains  []string
+	}{
+		{
+			name:     "unknown key",
+			yaml:     baseYAML + "stripeclient:\n  secretKey: sk_test_1\n",
+			errs:     -1,
+			contains: []string{"stripeclient"},
+		},
+		{
+			name: "every problem is reported",
+			yaml: `
+server:
+  environment: qa
+  port: "80800"
+  logLevel: loud
+rateLimit:
+  store: redis
+  groups:
+    refunds:
+      rate: 0
+      burst: 0
+tracing:
+  exporter: otlp
+  sampleRatio: 2
+`,
+			errs: 11,
+			contains: []string{
+				"server.environment", "server.port", "server.logLevel", "database.host", "database.user", "database.db",
+				"rateLimit.store", "rateLimit.groups.refunds", "tracing.endpoint", "tracing.sampleRatio",
+				"security.encryptionKey is required",
+			},
+		},
+		{
+			name:     "env value and file",
+			yaml:     baseYAML,
+			env:      map[string]string{"POSTGRES_PASSWORD": "a", "POSTGRES_PASSWORD_FILE": "/nonexistent"},
+			errs:     1,
+			contains: []string{"POSTGRES_PASSWORD and POSTGRES_PASSWORD_FILE are both set"},
+		},
+		{
+			name:     "malformed env",
+			yaml:     baseYAML,
+			env:      map[string]string{"DEBUG": "sometimes"},
+			errs:     1,
+			contains: []string{"DEBUG"},
+		},
+		{
+			name:      "unknown override",
+			yaml:      baseYAML,
+			overrides: []string{"server.colour=blue", "server.port"},
+			errs:      2,
+			contains:  []string{"server.colour", "not key=value"},
+		},
+		{
+			name:     "jwt without keys",
+			yaml:     baseYAML + "jwt:\n  enabled: true\n",
+			errs:     1,
+			contains: []string{"jwt.jwksUrl"},
+		},
+		{
+			name:      "encryption key of the wrong size",
+			yaml:      baseYAML,
+			overrides: []string{"security.encryptionKey=c2hvcnQ="},
+			errs:      1,
+			contains:  []string{"security.encryptionKey is not the base64 encoding of 32 bytes"},
+		},
+		{
+			name:      "encryption key not base64",
+			yaml:      baseYAML,
+			overrides: []string{"security.encryptionKey=not base64"},
+			errs:      1,
+			contains:  []string{"security.encryptionKey is not the base64"},
+		},
+		{
+			name:     "grpc on the http port",
+			yaml:     baseYAML + "grpc:\n  enabled: true\n  port: 8080\n",
+			errs:     1,
+			contains: []string{"grpc.port"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			path := writeFile(t, t.TempDir(), "config.yaml", tt.yaml)
+
+			err := config.InitConfig(path, "", tt.overrides...)
+			require.Error(t, err)
+
+			for _, s := range tt.contains {
+				assert.Contains(t, err.Error(), s)
+			}
+
+			if tt.errs > 0 {
+				var errs config.Errors
+
+				require.ErrorAs(t, err, &errs)
+				assert.Len(t, errs, tt.errs)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	logger.InitLogger()
+
+	defer func() { _ = logger.SetLevel("info") }()
+
+	path := writeFile(t, t.TempDir(), "config.yaml", baseYAML)
+
+	require.NoError(t, config.InitConfig(path, ""))
+
+	rateLimit := config.GetGlobalConfig().GetRateLimitConfig()
+
+	rule, ok := rateLimit.Rule("payments")
+	require.True(t, ok)
+	assert.Equal(t, 20, rule.Burst)
+
+	// the log level and rate limits change, the port only on restart
+	writeFile(t, filepath.Dir(path), "config.yaml", `
+server:
+  port: 9999
+  logLevel: warn
+database:
+  user: postgres
+  host: localhost
+  db: payments
+rateLimit:
+  groups:
+    payments:
+      rate: 1
+      burst: 2
+security:
+  encryptionKey: AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+`)
+
+	require.NoError(t, config.Reload(context.Background()))
+
+	assert.Equal(t, "warn", logger.Level())
+	assert.Equal(t, "8080", config.GetGlobalConfig().GetServerConfig().Port)
+
+	rule, ok = rateLimit.Rule("payments")
+	require.True(t, ok)
+	assert.Equal(t, 2, rule.Burst)
+
+	// an invalid config is not applied
+	writeFile(t, filepath.Dir(path), "config.yaml", "server:\n  logLevel: loud\n")
+
+	require.Error(t, config.Reload(context.Background()))
+	assert.Equal(t, "warn", logger.Level())
+
+	rule, _ = rateLimit.Rule("payments")
+	assert.Equal(t, 2, rule.Burst)
+}
+
+func TestReloadEnvFile(t *testing.T) {
+	logger.InitLogger()
+
+	defer func() { _ = logger.SetLevel("info") }()
+
+	_, set := os.LookupEnv("LOG_LEVEL")
+	require.False(t, set, "LOG_LEVEL is set in the environment")
+
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", baseYAML)
+	envPath := writeFile(t, dir, ".env", "LOG_LEVEL=warn\n")
+
+	// loading an empty env file unsets the variables set from it
+	defer func() {
+		writeFile(t, dir, ".env", "")
+		_ = config.InitConfig(path, envPath)
+	}()
+
+	require.NoError(t, config.InitConfig(path, envPath))
+	assert.Equal(t, "warn", config.GetGlobalConfig().GetServerConfig().LogLevel)
+
+	server := config.GetGlobalConfig().GetServerConfig()
+
+	// edits of the env file apply on reload
+	writeFile(t, dir, ".env", "LOG_LEVEL=error\n")
+
+	require.NoError(t, config.Reload(context.Background()))
+	assert.Equal(t, "error", logger.Level())
+	assert.Equal(t, "error", config.GetGlobalConfig().GetServerConfig().LogLevel)
+	assert.Equal(t, "warn", server.LogLevel, "copies handed out are not written to")
+
+	// variables removed from the env file fall back to the config file
+	writeFile(t, dir, ".env", "")
+
+	require.NoError(t, config.Reload(context.Background()))
+	assert.Equal(t, "info", logger.Level())
+
+	_, set = os.LookupEnv("LOG_LEVEL")
+	assert.False(t, set)
+}
@@ -0,0 +1,332 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+)
+
+// fileSuffix is appended to an env variable to read its value from a file, such as POSTGRES_PASSWORD_FILE.
+const fileSuffix = "_FILE"
+
+// source remembers where the running config was loaded from, so that it can be reloaded.
+type source struct {
+	path      string
+	envPath   string
+	overrides []string
+}
+
+var loadedFrom source
+
+// fromEnvFile are the variables set from the env file rather than by the environment,
+// reloading sets them again so that edits of the env file apply.
+var (
+	envFileMutex sync.Mutex
+	fromEnvFile  = make(map[string]bool)
+)
+
+// InitConfig loads the config, each layer overriding the ones before it:
+// the defaults, the yaml file at path, the environment with the variables of the env file at envPath added,
+// then the overrides, which are key=value pairs with the key a dot separated yaml path such as server.port.
+// the config is validated and every problem found is returned at once.
+func InitConfig(path string, envPath string, overrides ...string) error {
+	src := source{path: path, envPath: envPath, overrides: overrides}
+
+	cfg, err := load(src)
+	if err != nil {
+		logger.Logger.Error(context.TODO(), "error loading config", err)
+
+		return err
+	}
+
+	config = cfg
+	loadedFrom = src
+
+	return nil
+}
+
+func load(src source) (*GlobalConfig, error) {
+	cfg := defaults()
+
+	configFile, err := os.ReadFile(src.path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = decodeYAML(configFile, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", src.path, err)
+	}
+
+	if src.envPath != "" {
+		err = loadEnvFile(src.envPath)
+		if err != nil {
+			return nil, fmt.Errorf("env file %s: %w", src.envPath, err)
+		}
+	}
+
+	var errs Errors
+
+	errs = append(errs, loadEnv(reflect.ValueOf(cfg).Elem())...)
+
+	for _, override := range src.overrides {
+		err = applyOverride(cfg, override)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return cfg, nil
+}
+
+// loadEnvFile sets the variables of the env file at path in the environment, a missing file sets none.
+// variables already set in the environment win over the env file, those set from it before are replaced
+// and those removed from it are unset.
+func loadEnvFile(path string) error {
+	values, err := godotenv.Read(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	envFileMutex.Lock()
+	defer envFileMutex.Unlock()
+
+	for name := range fromEnvFile {
+		if _, ok := values[name]; !ok {
+			_ = os.Unsetenv(name)
+			delete(fromEnvFile, name)
+		}
+	}
+
+	for name, value := range values {
+		if _, set := os.LookupEnv(name); set && !fromEnvFile[name] {
+			continue
+		}
+
+		err = os.Setenv(name, value)
+		if err != nil {
+			return err
+		}
+
+		fromEnvFile[name] = true
+	}
+
+	return nil
+}
+
+// defaults returns the config used for everything the file, the environment and the overrides leave out.
+func defaults() *GlobalConfig {
+	return &GlobalConfig{
+		Server: Server{
+			Environment:     EnvironmentDev,
+			Port:            "8080",
+			Timeout:         15,
+			ShutdownTimeout: 30,
+			ErrorFormat:     ErrorFormatEnvelope,
+		},
+		DB: DB{
+			Port:           "5432",
+			Timeout:        30,
+			SSLMode:        "disable",
+			ConnectRetries: 5,
+			MaxReplicaLag:  10,
+		},
+		RateLimit: RateLimit{
+			Store: "memory",
+		},
+		Log: Log{
+			Encoding: "json",
+		},
+		Health: Health{
+			Timeout:        2,
+			StripeCacheTTL: 30,
+		},
+		GRPC: GRPC{
+			Port: "9090",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+			ServiceName: "stripe-pay-service",
+		},
+	}
+}
+
+// decodeYAML decodes the yaml document into cfg, keys not known to the config are errors rather than typos ignored.
+func decodeYAML(data []byte, cfg *GlobalConfig) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	return decoder.Decode(cfg)
+}
+
+// loadEnv sets the fields tagged with an env variable from the environment, or from the file named by the variable
+// with the _FILE suffix. fields whose variable is not set keep their value.
+func loadEnv(v reflect.Value) Errors {
+	var errs Errors
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			errs = append(errs, loadEnv(v.Field(i))...)
+
+			continue
+		}
+
+		name, ok := field.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+
+		value, ok, err := lookupEnv(name)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		err = setValue(v.Field(i), value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	return errs
+}
+
+// lookupEnv returns the value of the env variable name, read from the file named by name_FILE when that is set.
+func lookupEnv(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(name)
+	path, fromFile := os.LookupEnv(name + fileSuffix)
+
+	if !fromFile {
+		return strings.TrimSpace(value), ok, nil
+	}
+
+	if ok {
+		return "", false, fmt.Errorf("%s and %s%s are both set", name, name, fileSuffix)
+	}
+
+	content, err := os.ReadFile(strings.TrimSpace(path))
+	if err != nil {
+		return "", false, fmt.Errorf("%s%s: %w", name, fileSuffix, err)
+	}
+
+	return strings.TrimSpace(string(content)), true, nil
+}
+
+// applyOverride sets the field at the yaml path of a key=value override.
+func applyOverride(cfg *GlobalConfig, override string) error {
+	key, value, ok := strings.Cut(override, "=")
+	if !ok {
+		return fmt.Errorf("override %q is not key=value", override)
+	}
+
+	v := reflect.ValueOf(cfg).Elem()
+
+	for _, name := range strings.Split(key, ".") {
+		field, ok := fieldByYAMLName(v, name)
+		if !ok {
+			return fmt.Errorf("override %q: unknown config key %s", override, key)
+		}
+
+		v = field
+	}
+
+	err := setValue(v, strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("override %q: %w", override, err)
+	}
+
+	return nil
+}
+
+func fieldByYAMLName(v reflect.Value, name string) (reflect.Value, bool) {
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if tag == name && v.Type().Field(i).IsExported() {
+			return v.Field(i), true
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+// setValue parses value into v, lists are comma separated.
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("%s can not be set from text", v.Type())
+		}
+
+		var items []string
+
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s can not be set from text", v.Type())
+	}
+
+	return nil
+}
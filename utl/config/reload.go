@@ -0,0 +1,125 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+)
+
+// reloadDelay lets the burst of events an editor or a config map update makes settle before reloading.
+const reloadDelay = 500 * time.Millisecond
+
+// Reload loads the config again from where InitConfig loaded it and applies the settings safe to change while
+// running: the log level and the rate limits. the running config is kept when the new one is invalid.
+// other settings take effect on the next start.
+func Reload(ctx context.Context) error {
+	cfg, err := load(loadedFrom)
+	if err != nil {
+		logger.Logger.Error(ctx, "error reloading config, keeping the running one", err)
+
+		return err
+	}
+
+	err = config.apply(cfg)
+	if err != nil {
+		logger.Logger.Error(ctx, "error applying reloaded config", err)
+
+		return err
+	}
+
+	logger.Logger.Info(ctx, "config reloaded", loadedFrom.path)
+
+	return nil
+}
+
+// apply copies the reloadable settings of cfg into c.
+func (c *GlobalConfig) apply(cfg *GlobalConfig) error {
+	err := logger.SetLevel(cfg.logLevel())
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.Server.LogLevel = cfg.Server.LogLevel
+	c.mutex.Unlock()
+
+	c.RateLimit.setGroups(cfg.RateLimit.Groups)
+
+	return nil
+}
+
+// logLevel is the configured level, debug in debug mode and info otherwise when none is set.
+func (c *GlobalConfig) logLevel() string {
+	switch {
+	case c.Server.LogLevel != "":
+		return c.Server.LogLevel
+	case c.Server.Debug:
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
+// Watch reloads the config whenever the config or env file changes, until ctx is done.
+// the directories are watched rather than the files, which editors and config maps replace instead of writing to.
+func Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]bool)
+
+	for _, path := range []string{loadedFrom.path, loadedFrom.envPath} {
+		if path == "" {
+			continue
+		}
+
+		files[filepath.Clean(path)] = true
+
+		err = watcher.Add(filepath.Dir(path))
+		if err != nil {
+			_ = watcher.Close()
+
+			return err
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if files[filepath.Clean(event.Name)] && event.Op != fsnotify.Chmod {
+					timer.Reset(reloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				logger.Logger.Error(ctx, "error watching config files", err)
+			case <-timer.C:
+				_ = Reload(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Errors is every problem found in a config, reported together so that they can be fixed in one go.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return fmt.Sprintf("invalid config: %s", strings.Join(msgs, "; "))
+}
+
+// Validate checks that the required settings are set and that the others hold known values.
+func (c *GlobalConfig) Validate() error {
+	errs := c.validate()
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (c *GlobalConfig) validate() Errors {
+	var errs Errors
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(oneOf(c.Server.Environment, EnvironmentDev, EnvironmentStaging, EnvironmentProd),
+		"server.environment %q is not dev, staging or prod", c.Server.Environment)
+	check(validPort(c.Server.Port), "server.port %q is not a port", c.Server.Port)
+	check(c.Server.Timeout >= 0, "server.timeout must not be negative")
+	check(c.Server.ShutdownTimeout >= 0, "server.shutdownTimeout must not be negative")
+	check(oneOf(c.Server.ErrorFormat, ErrorFormatEnvelope, ErrorFormatProblem),
+		"server.errorFormat %q is not envelope or problem", c.Server.ErrorFormat)
+	check(oneOf(c.Server.LogLevel, "", "debug", "info", "warn", "error", "dpanic", "panic", "fatal"),
+		"server.logLevel %q is not one of debug, info, warn or error", c.Server.LogLevel)
+
+	check(oneOf(c.Log.Encoding, "", "json", "console"), "log.encoding %q is not json or console", c.Log.Encoding)
+
+	check(c.DB.Host != "", "database.host is required")
+	check(c.DB.User != "", "database.user is required")
+	check(c.DB.Database != "", "database.db is required")
+	check(validPort(c.DB.Port), "database.port %q is not a port", c.DB.Port)
+	check(oneOf(c.DB.SSLMode, "", "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+		"database.sslMode %q is not a libpq sslmode", c.DB.SSLMode)
+	check(c.DB.MaxOpenConns >= 0 && c.DB.MaxIdleConns >= 0, "database pool sizes must not be negative")
+	check(c.DB.StatementTimeout >= 0, "database.statementTimeout must not be negative")
+
+	check(!c.JWT.Enabled || c.JWT.JWKSURL != "" || len(c.JWT.StaticKeys) > 0,
+		"jwt.jwksUrl or jwt.staticKeys is required when jwt is enabled")
+
+	check(oneOf(c.RateLimit.Store, "", "memory", "postgres"), "rateLimit.store %q is not memory or postgres", c.RateLimit.Store)
+
+	for group, rule := range c.RateLimit.Groups {
+		check(rule.Rate > 0 && rule.Burst >= 1, "rateLimit.groups.%s needs a positive rate and a burst of at least 1", group)
+	}
+
+	check(oneOf(c.Tracing.Exporter, "", "none", "stdout", "otlp"),
+		"tracing.exporter %q is not otlp, stdout or none", c.Tracing.Exporter)
+	check(c.Tracing.Exporter != "otlp" || c.Tracing.Endpoint != "", "tracing.endpoint is required with the otlp exporter")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleRatio %v is not between 0 and 1", c.Tracing.SampleRatio)
+
+	check(oneOf(c.Secrets.Provider, "", "env", "file", "vault"), "secrets.provider %q is not env, file or vault", c.Secrets.Provider)
+	check(c.Secrets.Provider != "vault" || (c.Secrets.Vault.Address != "" && c.Secrets.Vault.Token != ""),
+		"secrets.vault.address and secrets.vault.token are required with the vault provider")
+	check(c.Secrets.RefreshInterval >= 0, "secrets.refreshInterval must not be negative")
+
+	check(validPort(c.GRPC.Port), "grpc.port %q is not a port", c.GRPC.Port)
+	check(!c.GRPC.Enabled || c.GRPC.Port != c.Server.Port, "grpc.port must differ from server.port")
+
+	check(c.Security.EncryptionKey != "", "security.encryptionKey is required")
+	check(c.Security.EncryptionKey == "" || validEncryptionKey(c.Security.EncryptionKey),
+		"security.encryptionKey is not the base64 encoding of 32 bytes")
+
+	check(c.Health.Timeout >= 0 && c.Health.StripeCacheTTL >= 0, "health durations must not be negative")
+
+	return errs
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+
+	return err == nil && n > 0 && n < 65536
+}
+
+// validEncryptionKey reports whether key is the base64 encoded 32 byte key encrypt.New expects.
+func validEncryptionKey(key string) bool {
+	raw, err := base64.StdEncoding.DecodeString(key)
+
+	return err == nil && len(raw) == 32
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,6 +2,8 @@ package constant
 
 const PostgresTxKey string = "postgres.tx"
 const RequestIDKey string = "request.id"
+const PrincipalKey string = "auth.principal"
+const PaymentIDKey string = "payment.id"
 
 type TxKey string
 
@@ -0,0 +1,79 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Cipher encrypts small secrets, such as api keys, with AES-256-GCM.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// New creates a cipher from a base64 encoded 32 byte key.
+func New(key string) (*Cipher, error) {
+	if key == "" {
+		return nil, errors.New("encryption key is not set")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("decoding encryption key: %w", err)
+	}
+
+	if len(raw) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+// Encrypt returns the base64 encoded nonce and ciphertext of plaintext.
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt.
+func (c *Cipher) Decrypt(ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+
+	plaintext, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
@@ -0,0 +1,146 @@
+package fault
+
+import (
+	"net/http"
+	"sort"
+)
+
+// Kind is a domain error of the catalog: the stable code clients branch on, the http status it is answered with
+// and the message shown to users. messages are looked up by code, so clients can localise them.
+// errors of a retryable kind may succeed when the same request is sent again later.
+type Kind struct {
+	Code      string
+	Status    int
+	Message   string
+	Retryable bool
+}
+
+// New returns an error of the kind raised by service, res tells the caller how to resolve it
+// and err is the underlying error, which only reaches responses in debug mode.
+func (k *Kind) New(service, res string, err error) error {
+	return &HTTPError{
+		Status:    k.Status,
+		ErrCode:   k.Code,
+		Message:   k.Message,
+		Res:       res,
+		Err:       err,
+		Service:   service,
+		Retryable: k.Retryable,
+	}
+}
+
+func (k *Kind) Error() string {
+	return k.Code
+}
+
+// the catalog, codes are part of the api and must not change once released.
+var (
+	ErrBadRequest             = register("ERR_BAD_REQUEST", http.StatusBadRequest, "The request could not be read.")
+	ErrInvalidParams          = register("ERR_INVALID_PARAMS", http.StatusBadRequest, "The payment provider rejected the request parameters.")
+	ErrAmountTooSmall         = register("ERR_AMOUNT_TOO_SMALL", http.StatusBadRequest, "The amount is below the minimum chargeable amount.")
+	ErrAmountTooLarge         = register("ERR_AMOUNT_TOO_LARGE", http.StatusBadRequest, "The amount is above the maximum chargeable amount.")
+	ErrAlreadyCaptured        = register("ERR_ALREADY_CAPTURED", http.StatusBadRequest, "The payment was already captured.")
+	ErrAlreadyRefunded        = register("ERR_ALREADY_REFUNDED", http.StatusBadRequest, "The payment was already refunded.")
+	ErrUnexpectedState        = register("ERR_UNEXPECTED_STATE", http.StatusBadRequest, "The payment is not in a state allowing this operation.")
+	ErrInvalidLogLevel        = register("ERR_INVALID_LOG_LEVEL", http.StatusBadRequest, "The log level is not known.")
+	ErrUnauthorized           = register("ERR_UNAUTHORIZED", http.StatusUnauthorized, "Authentication is required.")
+	ErrCardDeclined           = register("ERR_CARD_DECLINED", http.StatusPaymentRequired, "The card was declined.")
+	ErrCardExpired            = register("ERR_CARD_EXPIRED", http.StatusPaymentRequired, "The card has expired.")
+	ErrCardInvalid            = register("ERR_CARD_INVALID", http.StatusPaymentRequired, "The card details are incorrect.")
+	ErrCardAuthRequired       = register("ERR_CARD_AUTHENTICATION_REQUIRED", http.StatusPaymentRequired, "The card requires the customer to authenticate the payment.")
+	ErrCardProcessing         = registerRetryable("ERR_CARD_PROCESSING", http.StatusPaymentRequired, "The card could not be processed, try again.")
+	ErrForbidden              = register("ERR_FORBIDDEN", http.StatusForbidden, "The credentials do not allow this operation.")
+	ErrMerchantRequired       = register("ERR_MERCHANT_REQUIRED", http.StatusForbidden, "The credentials are not scoped to a merchant.")
+	ErrUnknownMerchant        = register("ERR_UNKNOWN_MERCHANT", http.StatusForbidden, "The merchant is unknown.")
+	ErrNotFound               = register("ERR_NOT_FOUND", http.StatusNotFound, "The resource does not exist.")
+	ErrPaymentNotFound        = register("ERR_PAYMENT_NOT_FOUND", http.StatusNotFound, "The payment does not exist.")
+	ErrMethodNotAllowed       = register("ERR_METHOD_NOT_ALLOWED", http.StatusMethodNotAllowed, "The method is not allowed on this resource.")
+	ErrConcurrentUpdate       = registerRetryable("ERR_CONCURRENT_UPDATE", http.StatusConflict, "The payment was modified concurrently.")
+	ErrIllegalTransition      = register("ERR_ILLEGAL_STATUS_TRANSITION", http.StatusConflict, "The payment can not move to the requested status.")
+	ErrRefundPending          = register("ERR_REFUND_PENDING", http.StatusConflict, "A refund of the payment is still pending.")
+	ErrCaptureExpired         = register("ERR_CAPTURE_EXPIRED", http.StatusConflict, "The authorisation expired before the payment was captured.")
+	ErrChargeDisputed         = register("ERR_CHARGE_DISPUTED", http.StatusConflict, "The payment is disputed.")
+	ErrInsufficientBalance    = register("ERR_INSUFFICIENT_BALANCE", http.StatusConflict, "The account balance is too low for this operation.")
+	ErrIdempotencyConflict    = register("ERR_IDEMPOTENCY_CONFLICT", http.StatusConflict, "The idempotency key was already used with other parameters.")
+	ErrIdempotencyKeyInUse    = registerRetryable("ERR_IDEMPOTENCY_KEY_IN_USE", http.StatusConflict, "A request with the same idempotency key is in progress.")
+	ErrPayloadTooLarge        = register("ERR_PAYLOAD_TOO_LARGE", http.StatusRequestEntityTooLarge, "The request body is too large.")
+	ErrUnsupportedMedia       = register("ERR_UNSUPPORTED_MEDIA_TYPE", http.StatusUnsupportedMediaType, "The request content type is not supported.")
+	ErrValidation             = register("ERR_VALIDATION", http.StatusUnprocessableEntity, "The request has invalid fields.")
+	ErrUnknownStatus          = register("ERR_UNKNOWN_STATUS", http.StatusUnprocessableEntity, "The payment status is not known.")
+	ErrRateLimited            = registerRetryable("ERR_RATE_LIMITED", http.StatusTooManyRequests, "Too many requests, slow down.")
+	ErrCardDeclineRateLimited = registerRetryable("ERR_CARD_DECLINE_RATE_LIMITED", http.StatusTooManyRequests, "The card was declined too often, try again later.")
+	ErrInternal               = register("ERR_INTERNAL_SERVER_ERROR", http.StatusInternalServerError, "Something went wrong, try again later.")
+	ErrStripeModeMismatch     = register("ERR_STRIPE_MODE_MISMATCH", http.StatusInternalServerError, "The payment provider is misconfigured.")
+	ErrStripeAuthentication   = register("ERR_STRIPE_AUTHENTICATION", http.StatusBadGateway, "The payment provider rejected the service credentials.")
+	ErrStripePermission       = register("ERR_STRIPE_PERMISSION", http.StatusBadGateway, "The payment provider credentials do not allow this operation.")
+	ErrStripeUnavailable      = registerRetryable("ERR_STRIPE_UNAVAILABLE", http.StatusBadGateway, "The payment provider could not be reached, try again later.")
+	ErrUnavailable            = registerRetryable("ERR_SERVICE_UNAVAILABLE", http.StatusServiceUnavailable, "The service is unavailable, try again later.")
+	ErrStripeRateLimited      = registerRetryable("ERR_STRIPE_RATE_LIMITED", http.StatusServiceUnavailable, "The payment provider is throttling requests, try again later.")
+)
+
+var catalog = make(map[string]*Kind)
+
+func register(code string, status int, message string) *Kind {
+	if _, ok := catalog[code]; ok {
+		panic("error code " + code + " registered twice")
+	}
+
+	k := &Kind{Code: code, Status: status, Message: message}
+	catalog[code] = k
+
+	return k
+}
+
+func registerRetryable(code string, status int, message string) *Kind {
+	k := register(code, status, message)
+	k.Retryable = true
+
+	return k
+}
+
+// Lookup returns the kind of a code.
+func Lookup(code string) (*Kind, bool) {
+	k, ok := catalog[code]
+
+	return k, ok
+}
+
+// Catalog returns every kind ordered by code.
+func Catalog() []*Kind {
+	kinds := make([]*Kind, 0, len(catalog))
+	for _, k := range catalog {
+		kinds = append(kinds, k)
+	}
+
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i].Code < kinds[j].Code
+	})
+
+	return kinds
+}
+
+// generic kinds answer http errors raised outside of the domain, such as unknown routes.
+var generic = map[int]*Kind{
+	http.StatusBadRequest:            ErrBadRequest,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusMethodNotAllowed:      ErrMethodNotAllowed,
+	http.StatusRequestEntityTooLarge: ErrPayloadTooLarge,
+	http.StatusUnsupportedMediaType:  ErrUnsupportedMedia,
+	http.StatusTooManyRequests:       ErrRateLimited,
+	http.StatusServiceUnavailable:    ErrUnavailable,
+}
+
+// ForStatus returns the generic kind of an http status, a bad request or an internal error for statuses without one.
+func ForStatus(status int) *Kind {
+	if k, ok := generic[status]; ok {
+		return k
+	}
+
+	if status >= http.StatusBadRequest && status < http.StatusInternalServerError {
+		return ErrBadRequest
+	}
+
+	return ErrInternal
+}
@@ -7,11 +7,25 @@ type HTTPError struct {
 	Res     string
 	Err     error
 	Service string
+	// Retryable tells clients the same request may succeed when sent again later.
+	Retryable bool
+	// Details are safe to show to clients, such as the decline code of a card.
+	Details map[string]string
+	// Fields lists the invalid fields of a request.
+	Fields []FieldError
+}
+
+// FieldError is a field of a request failing validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
 }
 
 func New(statusCode int, service, msg, res, errCode string, err error) error {
 	return &HTTPError{
 		Status:  statusCode,
+		ErrCode: errCode,
 		Message: msg,
 		Res:     res,
 		Err:     err,
@@ -19,14 +33,40 @@ func New(statusCode int, service, msg, res, errCode string, err error) error {
 	}
 }
 
+// ErrResponse is the error answered to clients, Err holds the underlying error text in debug mode only.
 type ErrResponse struct {
-	StatusCode int         `json:"status"`
-	Message    interface{} `json:"message"`
-	Res        string      `json:"res"`
-	Service    string      `json:"service,omitempty"`
-	Err        string      `json:"error"`
+	StatusCode int               `json:"status"`
+	Code       string            `json:"code"`
+	Message    string            `json:"message"`
+	Res        string            `json:"res"`
+	Service    string            `json:"service,omitempty"`
+	Retryable  bool              `json:"retryable"`
+	Details    map[string]string `json:"details,omitempty"`
+	Fields     []FieldError      `json:"fields,omitempty"`
+	Err        string            `json:"error,omitempty"`
+}
+
+// Envelope wraps the error answered to clients not asking for problem documents.
+type Envelope struct {
+	Error ErrResponse `json:"error"`
 }
 
 func (e *HTTPError) Error() string {
+	if e.Err == nil {
+		return e.ErrCode
+	}
+
 	return e.Err.Error()
 }
+
+// Unwrap returns the underlying error.
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the catalog kind the error was raised from, so errors.Is(err, fault.ErrAlreadyCaptured) holds.
+func (e *HTTPError) Is(target error) bool {
+	k, ok := target.(*Kind)
+
+	return ok && k.Code == e.ErrCode
+}
@@ -0,0 +1,45 @@
+package fault
+
+// MIMEProblemJSON is the media type of rfc 7807 problem documents.
+const MIMEProblemJSON = "application/problem+json"
+
+// problemTypePrefix prefixes catalog codes to build the type uri of problems.
+const problemTypePrefix = "urn:stripe-pay-service:error:"
+
+// Problem is an rfc 7807 problem details document. code, retryable, service, details and errors
+// are extension members carrying the same information as the error envelope.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Code      string            `json:"code"`
+	Retryable bool              `json:"retryable"`
+	Service   string            `json:"service,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	Errors    []FieldError      `json:"errors,omitempty"`
+	Err       string            `json:"error,omitempty"`
+}
+
+// ProblemType returns the type uri of the problems of a catalog code.
+func ProblemType(code string) string {
+	return problemTypePrefix + code
+}
+
+// Problem renders the response as a problem document, instance identifies the request which failed.
+func (r ErrResponse) Problem(instance string) Problem {
+	return Problem{
+		Type:      ProblemType(r.Code),
+		Title:     r.Message,
+		Status:    r.StatusCode,
+		Detail:    r.Res,
+		Instance:  instance,
+		Code:      r.Code,
+		Retryable: r.Retryable,
+		Service:   r.Service,
+		Details:   r.Details,
+		Errors:    r.Fields,
+		Err:       r.Err,
+	}
+}
@@ -0,0 +1,175 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+)
+
+// defaults used when the config leaves them unset.
+const (
+	DefaultTimeout  = 2 * time.Second
+	DefaultCacheTTL = 30 * time.Second
+)
+
+// check statuses.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check reports whether a dependency can be used, a nil error means it can.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of a single check. a failed check has the code of the fault catalog,
+// Error holds the text of the underlying error, which is internal.
+type Result struct {
+	Status     string  `json:"status"`
+	Code       string  `json:"code,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"durationMs"`
+}
+
+// Report is the outcome of every check, Status is ok only when all of them are.
+// Info describes the running instance, such as the stripe mode.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+	Info   map[string]string `json:"info,omitempty"`
+}
+
+// Checker runs the registered dependency checks.
+type Checker struct {
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+	info    map[string]string
+}
+
+// NewChecker returns a checker giving every check at most timeout to finish.
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Checker{
+		timeout: timeout,
+		checks:  make(map[string]Check),
+	}
+}
+
+// Register adds a check reported under name.
+func (c *Checker) Register(name string, check Check) {
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+
+	c.checks[name] = check
+}
+
+// SetInfo adds a fact about the running instance reported alongside the checks.
+func (c *Checker) SetInfo(key, value string) {
+	if c.info == nil {
+		c.info = make(map[string]string)
+	}
+
+	c.info[key] = value
+}
+
+// Run runs every check concurrently and reports their outcome.
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{
+		Status: StatusOK,
+		Checks: make(map[string]Result, len(c.names)),
+		Info:   c.info,
+	}
+
+	results := make([]Result, len(c.names))
+
+	var wg sync.WaitGroup
+
+	for i, name := range c.names {
+		wg.Add(1)
+
+		go func(i int, check Check) {
+			defer wg.Done()
+
+			results[i] = c.run(ctx, check)
+		}(i, c.checks[name])
+	}
+
+	wg.Wait()
+
+	for i, name := range c.names {
+		report.Checks[name] = results[i]
+
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := Result{
+		Status:     StatusOK,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if err != nil {
+		result.Status = StatusFail
+		result.Code = fault.ErrUnavailable.Code
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// Cached returns a check reusing the outcome of check for ttl, for dependencies too costly to check on every probe.
+func Cached(check Check, ttl time.Duration) Check {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	var (
+		mutex     sync.Mutex
+		checkedAt time.Time
+		lastErr   error
+	)
+
+	return func(ctx context.Context) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !checkedAt.IsZero() && time.Since(checkedAt) < ttl {
+			return lastErr
+		}
+
+		lastErr = check(ctx)
+		checkedAt = time.Now()
+
+		return lastErr
+	}
+}
+
+// Database checks that a connection to the database can be made.
+func Database(db *gorm.DB) Check {
+	return func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+
+		return sqlDB.PingContext(ctx)
+	}
+}
@@ -0,0 +1,141 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	healthHTTP "github.com/swagftw/stripe_pay_service/transport/health"
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/health"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+)
+
+// encryptionKeyOverride sets the encryption key the config requires, .env leaves it empty.
+const encryptionKeyOverride = "security.encryptionKey=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+func ok(context.Context) error { return nil }
+
+func down(context.Context) error { return errors.New("connection refused") }
+
+// hanging waits for the checker to give up on it.
+func hanging(ctx context.Context) error {
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+func TestReadiness(t *testing.T) {
+	logger.InitLogger()
+
+	unavailable := fault.ErrUnavailable.Code
+
+	cases := []struct {
+		name       string
+		checks     map[string]health.Check
+		debug      bool
+		wantStatus int
+		wantChecks map[string]health.Result
+	}{
+		{
+			name:       "all dependencies up",
+			checks:     map[string]health.Check{"database": ok, "stripe": ok},
+			wantStatus: http.StatusOK,
+			wantChecks: map[string]health.Result{"database": {Status: health.StatusOK}, "stripe": {Status: health.StatusOK}},
+		},
+		{
+			name:       "database down",
+			checks:     map[string]health.Check{"database": down, "stripe": ok},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]health.Result{"database": {Status: health.StatusFail, Code: unavailable}, "stripe": {Status: health.StatusOK}},
+		},
+		{
+			name:       "database down in debug mode",
+			checks:     map[string]health.Check{"database": down},
+			debug:      true,
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]health.Result{"database": {Status: health.StatusFail, Code: unavailable, Error: "connection refused"}},
+		},
+		{
+			name:       "check times out",
+			checks:     map[string]health.Check{"stripe": hanging},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]health.Result{"stripe": {Status: health.StatusFail, Code: unavailable}},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := config.InitConfig("../../config/config.local.yaml", "", fmt.Sprintf("server.debug=%t", tt.debug), encryptionKeyOverride)
+			require.NoError(t, err)
+
+			checker := health.NewChecker(20 * time.Millisecond)
+			for name, check := range tt.checks {
+				checker.Register(name, check)
+			}
+
+			checker.SetInfo("stripeMode", "test")
+
+			e := echo.New()
+			healthHTTP.InitHTTPHandlers(e, checker)
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, healthHTTP.ReadinessPath, nil))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			var report health.Report
+			if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report)) {
+				return
+			}
+
+			for name, result := range report.Checks {
+				result.DurationMS = 0
+				report.Checks[name] = result
+			}
+
+			assert.Equal(t, tt.wantChecks, report.Checks)
+			assert.Equal(t, tt.wantStatus == http.StatusOK, report.Status == health.StatusOK)
+			assert.Equal(t, map[string]string{"stripeMode": "test"}, report.Info)
+		})
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	checker := health.NewChecker(0)
+	checker.Register("database", down)
+
+	e := echo.New()
+	healthHTTP.InitHTTPHandlers(e, checker)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, healthHTTP.LivenessPath, nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
+
+func TestCached(t *testing.T) {
+	calls := 0
+	check := health.Cached(func(context.Context) error {
+		calls++
+
+		return errors.New("unreachable")
+	}, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		assert.EqualError(t, check(context.TODO()), "unreachable")
+	}
+
+	assert.Equal(t, 1, calls)
+}
@@ -4,19 +4,53 @@ import (
 	"context"
 	"log"
 
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/redact"
 )
 
 var Logger *ZapLogger
 
+// level is shared by every logger built, changing it applies to the running logger immediately.
+var level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+// log encodings supported by Configure.
+const (
+	EncodingJSON    = "json"
+	EncodingConsole = "console"
+)
+
 type ZapLogger struct {
 	zapLogger *zap.Logger
 }
 
+// Options configures the logger built by Configure, zero values keep the production defaults.
+type Options struct {
+	// Level is the minimum level logged, debug, info, warn or error.
+	Level string
+	// Encoding is json or console.
+	Encoding string
+	// Development makes warnings carry stack traces and panics on DPanic.
+	Development bool
+	// SamplingInitial and SamplingThereafter sample repeated log lines each second:
+	// the first SamplingInitial are logged, then every SamplingThereafter-th. zero disables sampling.
+	SamplingInitial    int
+	SamplingThereafter int
+	// OutputPaths are where log lines are written, ErrorOutputPaths where internal logger errors are.
+	OutputPaths      []string
+	ErrorOutputPaths []string
+}
+
 // InitLogger initializes the logger globally
-// this is called at the beginning of the program(service)
+// this is called at the beginning of the program(service), before the config is loaded.
 func InitLogger() {
-	zapLogger, err := zap.NewProduction(
+	cfg := zap.NewProductionConfig()
+	cfg.Level = level
+
+	zapLogger, err := cfg.Build(
 		zap.AddCallerSkip(1),
 	)
 	if err != nil {
@@ -26,17 +60,139 @@ func InitLogger() {
 	Logger = &ZapLogger{zapLogger: zapLogger}
 }
 
+// New returns a logger writing to core, such as an observer collecting log lines.
+func New(core zapcore.Core) *ZapLogger {
+	return &ZapLogger{zapLogger: zap.New(core)}
+}
+
+// Configure replaces the global logger with one built from opts, it is called once the config is loaded.
+func Configure(opts Options) error {
+	cfg := zap.NewProductionConfig()
+	if opts.Development {
+		cfg = zap.NewDevelopmentConfig()
+	}
+
+	cfg.Level = level
+	cfg.Development = opts.Development
+
+	if opts.Encoding != "" {
+		cfg.Encoding = opts.Encoding
+	}
+
+	cfg.Sampling = nil
+	if opts.SamplingInitial > 0 && opts.SamplingThereafter > 0 {
+		cfg.Sampling = &zap.SamplingConfig{Initial: opts.SamplingInitial, Thereafter: opts.SamplingThereafter}
+	}
+
+	if len(opts.OutputPaths) > 0 {
+		cfg.OutputPaths = opts.OutputPaths
+	}
+
+	if len(opts.ErrorOutputPaths) > 0 {
+		cfg.ErrorOutputPaths = opts.ErrorOutputPaths
+	}
+
+	zapLogger, err := cfg.Build(
+		zap.AddCallerSkip(1),
+	)
+	if err != nil {
+		return err
+	}
+
+	if opts.Level != "" {
+		err = SetLevel(opts.Level)
+		if err != nil {
+			return err
+		}
+	}
+
+	Logger = &ZapLogger{zapLogger: zapLogger}
+
+	return nil
+}
+
+// Level returns the minimum level logged.
+func Level() string {
+	return level.String()
+}
+
+// SetLevel changes the minimum level logged by the running logger.
+func SetLevel(lvl string) error {
+	var zapLevel zapcore.Level
+
+	err := zapLevel.UnmarshalText([]byte(lvl))
+	if err != nil {
+		return err
+	}
+
+	level.SetLevel(zapLevel)
+
+	return nil
+}
+
+// DebugEnabled reports whether debug messages are logged, to skip building costly ones.
+func (l *ZapLogger) DebugEnabled() bool {
+	return level.Enabled(zapcore.DebugLevel)
+}
+
+// Sync flushes buffered log lines, it is called before the program exits.
+func (l *ZapLogger) Sync() error {
+	return l.zapLogger.Sync()
+}
+
 // Info logs an info message
 func (l *ZapLogger) Info(ctx context.Context, msg string, args ...interface{}) {
-	l.zapLogger.Info(msg, zap.Any("params", args))
+	l.zapLogger.Info(msg, append(contextFields(ctx), zap.Any("params", redact.Value(args)))...)
 }
 
 // Debug logs a debug message
 func (l *ZapLogger) Debug(ctx context.Context, msg string, args ...interface{}) {
-	l.zapLogger.Debug(msg, zap.Any("params", args))
+	l.zapLogger.Debug(msg, append(contextFields(ctx), zap.Any("params", redact.Value(args)))...)
+}
+
+// Warn logs a warning message
+func (l *ZapLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	l.zapLogger.Warn(msg, append(contextFields(ctx), zap.Any("params", redact.Value(args)))...)
 }
 
 // Error logs an error message
 func (l *ZapLogger) Error(ctx context.Context, msg string, err error, args ...interface{}) {
-	l.zapLogger.Error(msg, zap.Error(err), zap.Any("params", args))
+	l.zapLogger.Error(msg, append(contextFields(ctx), errorField(err), zap.Any("params", redact.Value(args)))...)
+}
+
+// errorField logs err with its secrets and PII masked, stripe errors carry the request and response they failed on.
+func errorField(err error) zap.Field {
+	if err == nil {
+		return zap.Skip()
+	}
+
+	return zap.String("error", redact.String(err.Error()))
+}
+
+// contextFields returns the fields correlating a log line with the request it was written for:
+// the request id, the merchant and payment intent worked on, and the trace.
+func contextFields(ctx context.Context) []zap.Field {
+	fields := make([]zap.Field, 0, 5)
+
+	if ctx == nil {
+		return fields
+	}
+
+	if requestID, ok := types.RequestIDFromContext(ctx); ok {
+		fields = append(fields, zap.String("request_id", requestID))
+	}
+
+	if merchantID, ok := types.MerchantIDFromContext(ctx); ok {
+		fields = append(fields, zap.String("merchant_id", merchantID))
+	}
+
+	if paymentID, ok := types.PaymentIDFromContext(ctx); ok {
+		fields = append(fields, zap.String("payment_id", paymentID))
+	}
+
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		fields = append(fields, zap.String("trace_id", spanCtx.TraceID().String()), zap.String("span_id", spanCtx.SpanID().String()))
+	}
+
+	return fields
 }
@@ -0,0 +1,120 @@
+package logger_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+)
+
+func TestContextFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := logger.New(core)
+
+	requestCtx := types.WithRequestID(context.Background(), "req_1")
+	merchantCtx := types.WithPrincipal(requestCtx, &types.Principal{ClientID: "mr_1", MerchantID: "mr_1"})
+	paymentCtx := types.WithPaymentID(merchantCtx, "pi_1")
+
+	cases := []struct {
+		name       string
+		ctx        context.Context
+		wantFields map[string]interface{}
+	}{
+		{name: "no request", ctx: context.TODO(), wantFields: map[string]interface{}{}},
+		{name: "request", ctx: requestCtx, wantFields: map[string]interface{}{"request_id": "req_1"}},
+		{name: "merchant", ctx: merchantCtx, wantFields: map[string]interface{}{"request_id": "req_1", "merchant_id": "mr_1"}},
+		{name: "payment", ctx: paymentCtx, wantFields: map[string]interface{}{"request_id": "req_1", "merchant_id": "mr_1", "payment_id": "pi_1"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			l.Error(tt.ctx, "capture failed", errors.New("card declined"))
+
+			entries := logs.TakeAll()
+			if !assert.Len(t, entries, 1) {
+				return
+			}
+
+			fields := entries[0].ContextMap()
+			for _, key := range []string{"request_id", "merchant_id", "payment_id"} {
+				assert.Equal(t, tt.wantFields[key], fields[key], key)
+			}
+
+			assert.Equal(t, "card declined", fields["error"])
+		})
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	defer func() { _ = logger.SetLevel("info") }()
+
+	out := filepath.Join(t.TempDir(), "service.log")
+
+	err := logger.Configure(logger.Options{Level: "info", Encoding: logger.EncodingJSON, OutputPaths: []string{out}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		name      string
+		level     string
+		wantErr   bool
+		wantDebug bool
+	}{
+		{name: "info hides debug", level: "info"},
+		{name: "debug shows debug", level: "debug", wantDebug: true},
+		{name: "unknown level keeps the current one", level: "verbose", wantErr: true, wantDebug: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := logger.SetLevel(tt.level)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantDebug, logger.Logger.DebugEnabled())
+
+			logger.Logger.Debug(context.TODO(), tt.name)
+			_ = logger.Logger.Sync()
+
+			written, err := os.ReadFile(out)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, tt.wantDebug, strings.Contains(string(written), `"msg":"`+tt.name+`"`))
+		})
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := logger.New(core)
+
+	l.Error(context.TODO(), "stripe call failed",
+		errors.New("confirming pi_1_secret_abc for jane@example.com"),
+		map[string]interface{}{"client_secret": "pi_1_secret_abc", "amount": 100})
+
+	entries := logs.TakeAll()
+	if !assert.Len(t, entries, 1) {
+		return
+	}
+
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "confirming [REDACTED] for [REDACTED]", fields["error"])
+
+	params, err := json.Marshal(fields["params"])
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.JSONEq(t, `[{"amount":100,"client_secret":"[REDACTED]"}]`, string(params))
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "stripe_pay"
+
+// http metrics, recorded by the echo middleware in utl/server.
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of http requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of http requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// stripe metrics, recorded by utl/stripeclient.
+var (
+	StripeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stripe_request_duration_seconds",
+		Help:      "Duration of stripe api calls by operation.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"operation"})
+
+	StripeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stripe_errors_total",
+		Help:      "Number of failed stripe api calls by operation and stripe error type.",
+	}, []string{"operation", "type"})
+)
+
+// DBQueryDuration is recorded by the gorm plugin in utl/storage.
+var DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "db_query_duration_seconds",
+	Help:      "Duration of database queries by operation and table.",
+	Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"operation", "table"})
+
+// business metrics, recorded by pkg/payments once the change is stored.
+// amounts are in the smallest currency unit, as stripe reports them.
+var (
+	IntentsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payment_intents_created_total",
+		Help:      "Number of payment intents created by currency.",
+	}, []string{"currency"})
+
+	CapturedAmount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "captured_amount_total",
+		Help:      "Captured amount in the smallest currency unit by currency.",
+	}, []string{"currency"})
+
+	RefundedAmount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refunded_amount_total",
+		Help:      "Refunded amount in the smallest currency unit by currency.",
+	}, []string{"currency"})
+)
+
+// RegisterDBStats exposes the connection pool statistics of db, such as open, in use and idle connections
+// and the time spent waiting for one, labelled with name.
+func RegisterDBStats(db *sql.DB, name string) error {
+	err := prometheus.Register(collectors.NewDBStatsCollector(db, name))
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return nil
+	}
+
+	return err
+}
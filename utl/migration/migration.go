@@ -2,51 +2,359 @@ package migration
 
 import (
 	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
 
 	"gorm.io/gorm"
 
-	"github.com/swagftw/stripe_pay_service/pkg/payments"
-	"github.com/swagftw/stripe_pay_service/utl/config"
 	"github.com/swagftw/stripe_pay_service/utl/logger"
-	"github.com/swagftw/stripe_pay_service/utl/storage"
 )
 
-func Migrate() {
-	err := config.InitConfig("./utl/config/config.local.yaml", "./.env")
+// Dir is where the migrations are kept in the source tree, new ones are created there.
+const Dir = "./utl/migration/sql"
+
+// lockKey is the postgres advisory lock held while migrating, so concurrent instances migrate one after the other.
+const lockKey = 4242001
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+// fileName matches migration files, such as 0002_add_livemode.up.sql.
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// migrationName matches the names migrations can be created with.
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Migration is a numbered schema change with the sql applying and reverting it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status tells whether a migration was applied and when.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// SchemaMigration is the record of an applied migration.
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (*SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies and reverts the migrations, recording them in schema_migrations.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator returns a migrator for the migrations embedded in the binary.
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	fsys, err := fs.Sub(embedded, "sql")
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	logger.Logger.Debug(context.TODO(), "Config loaded and getting db connection", nil)
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
 
-	db, err := storage.NewPostgresDB()
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Load reads the migrations at the root of fsys, ordered by version.
+// every migration needs an up file, the down file is optional but then it can not be reverted.
+func Load(fsys fs.FS) ([]Migration, error) {
+	paths, err := fs.Glob(fsys, "*.sql")
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	err = db.Transaction(func(tx *gorm.DB) error {
-		// create generate uid extension
-		err = db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\";\n\nCREATE EXTENSION IF NOT EXISTS pgcrypto;\n\nCREATE OR REPLACE FUNCTION generate_uid(size INT) RETURNS TEXT AS $$\nDECLARE\ncharacters TEXT := 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789';\n  bytes BYTEA := gen_random_bytes(size);\n  l INT := length(characters);\n  i INT := 0;\noutput TEXT := '';\nBEGIN\n  WHILE i < size LOOP\n    output := output || substr(characters, get_byte(bytes, i) % l + 1, 1);\n    i := i + 1;\nEND LOOP;\n  RETURN output;\nEND;\n$$ LANGUAGE plpgsql VOLATILE;").Error
+	byVersion := make(map[int]*Migration)
+
+	for _, path := range paths {
+		match := fileName.FindStringSubmatch(path)
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s is not named <version>_<name>.<up|down>.sql", path)
+		}
+
+		version, err := strconv.Atoi(match[1])
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
 		}
 
-		err = db.Exec("CREATE SCHEMA IF NOT EXISTS payment;").Error
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies every migration not applied yet, in version order, and returns the ones applied.
+// each migration runs in its own transaction together with its record.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		done, err := appliedVersions(conn)
 		if err != nil {
 			return err
 		}
 
-		// create payments related table
-		err = db.AutoMigrate(&payments.PaymentIntent{}, &payments.Refund{})
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			err = conn.Transaction(func(tx *gorm.DB) error {
+				err := tx.Exec(migration.Up).Error
+				if err != nil {
+					return err
+				}
+
+				return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			logger.Logger.Info(ctx, "migration applied", migration.Version, migration.Name)
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns the ones reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		done, err := appliedVersions(conn)
 		if err != nil {
 			return err
 		}
 
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file and can not be reverted", migration.Version, migration.Name)
+			}
+
+			err = conn.Transaction(func(tx *gorm.DB) error {
+				err := tx.Exec(migration.Down).Error
+				if err != nil {
+					return err
+				}
+
+				return tx.Delete(&SchemaMigration{}, migration.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			logger.Logger.Info(ctx, "migration reverted", migration.Version, migration.Name)
+
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status returns every known migration and when it was applied, nil when it is pending.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	db := m.db.WithContext(ctx)
+
+	// nothing was applied before the tracking table is created
+	done := make(map[int]SchemaMigration)
+
+	if db.Migrator().HasTable(&SchemaMigration{}) {
+		var err error
+
+		done, err = appliedVersions(db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+
+	for _, migration := range m.migrations {
+		status := Status{Migration: migration}
+
+		if record, ok := done[migration.Version]; ok {
+			status.AppliedAt = &record.AppliedAt
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Applied checks that every migration was applied.
+func Applied(ctx context.Context, db *gorm.DB) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
 		return err
+	}
+
+	pending := 0
+
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("%d migrations pending", pending)
+	}
+
+	return nil
+}
+
+// Create writes the up and down files for a new migration in dir, numbered after the last one there.
+func Create(dir, name string) (up string, down string, err error) {
+	if !migrationName.MatchString(name) {
+		return "", "", fmt.Errorf("migration name %q may only hold lower case letters, digits and underscores", name)
+	}
+
+	existing, err := Load(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+
+	version := 1
+	if len(existing) > 0 {
+		version = existing[len(existing)-1].Version + 1
+	}
+
+	up = filepath.Join(dir, fmt.Sprintf("%04d_%s.up.sql", version, name))
+	down = filepath.Join(dir, fmt.Sprintf("%04d_%s.down.sql", version, name))
+
+	files := map[string]string{
+		up:   fmt.Sprintf("-- applies %s\n", name),
+		down: fmt.Sprintf("-- reverts %s\n", name),
+	}
+
+	for path, content := range files {
+		err = os.WriteFile(path, []byte(content), 0o644)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return up, down, nil
+}
+
+// locked runs fc on a single connection holding the migration advisory lock.
+func (m *Migrator) locked(ctx context.Context, fc func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		// every statement built from the session starts fresh while staying on the connection
+		conn = conn.Session(&gorm.Session{})
+
+		err := conn.Exec("SELECT pg_advisory_lock(?)", lockKey).Error
+		if err != nil {
+			return err
+		}
+
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", lockKey)
+
+		err = createTable(conn)
+		if err != nil {
+			return err
+		}
+
+		return fc(conn)
 	})
+}
+
+func createTable(db *gorm.DB) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    BIGINT NOT NULL PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL
+)`).Error
+}
 
+func appliedVersions(db *gorm.DB) (map[int]SchemaMigration, error) {
+	var records []SchemaMigration
+
+	err := db.Find(&records).Error
 	if err != nil {
-		logger.Logger.Error(context.TODO(), "Error executing migration", err)
-		panic(err)
+		return nil, err
+	}
+
+	done := make(map[int]SchemaMigration, len(records))
+	for _, record := range records {
+		done[record.Version] = record
 	}
+
+	return done, nil
 }
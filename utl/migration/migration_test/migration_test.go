@@ -0,0 +1,226 @@
+package migration_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/migration"
+	"github.com/swagftw/stripe_pay_service/utl/storage"
+)
+
+// encryptionKeyOverride sets the encryption key the config requires, .env leaves it empty.
+const encryptionKeyOverride = "security.encryptionKey=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+func TestLoad(t *testing.T) {
+	file := func(content string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(content)}
+	}
+
+	cases := []struct {
+		name         string
+		fsys         fstest.MapFS
+		wantErr      bool
+		wantVersions []int
+	}{
+		{
+			name: "ordered by version",
+			fsys: fstest.MapFS{
+				"0010_add_livemode.up.sql":   file("ALTER TABLE a ADD livemode BOOLEAN;"),
+				"0010_add_livemode.down.sql": file("ALTER TABLE a DROP livemode;"),
+				"0002_init.up.sql":           file("CREATE TABLE a (id TEXT);"),
+			},
+			wantVersions: []int{2, 10},
+		},
+		{
+			name:    "down without up",
+			fsys:    fstest.MapFS{"0001_init.down.sql": file("DROP TABLE a;")},
+			wantErr: true,
+		},
+		{
+			name:    "badly named file",
+			fsys:    fstest.MapFS{"init.sql": file("CREATE TABLE a (id TEXT);")},
+			wantErr: true,
+		},
+		{
+			name: "version used by two names",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql":  file("CREATE TABLE a (id TEXT);"),
+				"0001_other.up.sql": file("CREATE TABLE b (id TEXT);"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := migration.Load(tt.fsys)
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			versions := make([]int, 0, len(migrations))
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+			}
+
+			if !tt.wantErr {
+				assert.Equal(t, tt.wantVersions, versions)
+			}
+		})
+	}
+}
+
+// the shipped migrations are numbered without gaps and can all be reverted.
+func TestShippedMigrations(t *testing.T) {
+	migrations, err := migration.Load(os.DirFS("../sql"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, m.Name)
+		assert.NotEmpty(t, m.Down, m.Name)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+
+	up, down, err := migration.Create(dir, "init")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "0001_init.up.sql"), up)
+	assert.Equal(t, filepath.Join(dir, "0001_init.down.sql"), down)
+
+	up, _, err = migration.Create(dir, "add_livemode")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "0002_add_livemode.up.sql"), up)
+
+	_, _, err = migration.Create(dir, "Add Livemode")
+	assert.Error(t, err)
+
+	migrations, err := migration.Load(os.DirFS(dir))
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 2)
+}
+
+// baselineSchema is the schema the AutoMigrate of the first release created, before the migrations.
+const baselineSchema = `
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+CREATE OR REPLACE FUNCTION generate_uid(size INT) RETURNS TEXT AS $$
+DECLARE
+characters TEXT := 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789';
+  bytes BYTEA := gen_random_bytes(size);
+  l INT := length(characters);
+  i INT := 0;
+output TEXT := '';
+BEGIN
+  WHILE i < size LOOP
+    output := output || substr(characters, get_byte(bytes, i) % l + 1, 1);
+    i := i + 1;
+END LOOP;
+  RETURN output;
+END;
+$$ LANGUAGE plpgsql VOLATILE;
+
+CREATE SCHEMA IF NOT EXISTS payment;
+
+CREATE TABLE payment.payment_intents (
+    id          TEXT NOT NULL DEFAULT ('pi_' || generate_uid(12)),
+    amount      BIGINT,
+    provider_id TEXT,
+    email       TEXT,
+    status      TEXT,
+    payload     JSONB,
+    created_at  TIMESTAMPTZ,
+    updated_at  TIMESTAMPTZ,
+    deleted_at  TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_payment_payment_intents_deleted_at ON payment.payment_intents (deleted_at);
+
+CREATE TABLE payment.refunds (
+    id                TEXT NOT NULL DEFAULT ('rf_' || generate_uid(12)),
+    provider_id       TEXT NOT NULL,
+    payment_intent_id TEXT NOT NULL,
+    amount            BIGINT NOT NULL,
+    status            TEXT NOT NULL,
+    created_at        TIMESTAMPTZ,
+    updated_at        TIMESTAMPTZ,
+    deleted_at        TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_payment_refunds_deleted_at ON payment.refunds (deleted_at);
+
+INSERT INTO payment.payment_intents (id, amount, provider_id, status, payload)
+VALUES ('pi_baseline', 5000, 'pi_stripe', 'succeeded', '{"livemode": true}');
+`
+
+// a database migrated by the AutoMigrate of the first release adopts the migrations and keeps its rows.
+func TestUpFromBaseline(t *testing.T) {
+	logger.InitLogger()
+
+	err := config.InitConfig("../../config/config.local.yaml", "../../../.env", "database.connectRetries=0", encryptionKeyOverride)
+	require.NoError(t, err)
+
+	admin, err := storage.NewPostgresDB()
+	require.NoError(t, err)
+
+	defer func() { _ = storage.Close(admin) }()
+
+	name := fmt.Sprintf("migration_baseline_%d", time.Now().UnixNano())
+
+	require.NoError(t, admin.Exec("CREATE DATABASE "+name).Error)
+
+	defer admin.Exec("DROP DATABASE IF EXISTS " + name)
+
+	dbCfg := config.GetGlobalConfig().GetDBConfig()
+	dbCfg.Database = name
+
+	db, err := gorm.Open(postgres.Open(storage.DSN(dbCfg)), &gorm.Config{})
+	require.NoError(t, err)
+
+	defer func() { _ = storage.Close(db) }()
+
+	require.NoError(t, db.Exec(baselineSchema).Error)
+
+	migrator, err := migration.NewMigrator(db)
+	require.NoError(t, err)
+
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	require.NoError(t, migration.Applied(context.Background(), db))
+
+	for table, columns := range map[string][]string{
+		"payment.payment_intents": {"merchant_id", "version", "livemode"},
+		"payment.refunds":         {"merchant_id"},
+	} {
+		for _, column := range columns {
+			assert.True(t, db.Migrator().HasColumn(table, column), "%s has no %s", table, column)
+		}
+	}
+
+	var row struct {
+		MerchantID *string
+		Version    int
+		Livemode   bool
+	}
+
+	require.NoError(t, db.Raw("SELECT merchant_id, version, livemode FROM payment.payment_intents WHERE id = 'pi_baseline'").Scan(&row).Error)
+	assert.Nil(t, row.MerchantID, "rows made before merchants are not assigned to one")
+	assert.Equal(t, 1, row.Version)
+	assert.True(t, row.Livemode)
+}
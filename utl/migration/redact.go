@@ -0,0 +1,68 @@
+package migration
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/pkg/payments"
+	"github.com/swagftw/stripe_pay_service/utl/redact"
+)
+
+// RedactPayloads masks the secrets and PII of the stripe payloads stored before redaction was in place.
+// rows are read batchSize at a time in id order and only the ones whose payload changes are written,
+// dry run only counts them. it returns the number of payloads redacted.
+func RedactPayloads(ctx context.Context, db *gorm.DB, batchSize int, dryRun bool) (int, error) {
+	redacted := 0
+	lastID := ""
+
+	for {
+		var batch []payments.PaymentIntent
+
+		err := db.WithContext(ctx).Unscoped().
+			Select("id", "payload").
+			Where("id > ?", lastID).
+			Order("id").
+			Limit(batchSize).
+			Find(&batch).Error
+		if err != nil {
+			return redacted, err
+		}
+
+		for _, payment := range batch {
+			lastID = payment.ID
+
+			if payment.Payload == "" {
+				continue
+			}
+
+			// postgres returns jsonb reformatted, so payloads are compared as documents rather than as text
+			payload, changed, err := redact.JSONChanged([]byte(payment.Payload))
+			if err != nil {
+				return redacted, err
+			}
+
+			if !changed {
+				continue
+			}
+
+			redacted++
+
+			if dryRun {
+				continue
+			}
+
+			// UpdateColumn leaves updated_at alone, the payment itself did not change
+			err = db.WithContext(ctx).Model(&payments.PaymentIntent{}).
+				Where("id = ?", payment.ID).
+				UpdateColumn("payload", string(payload)).Error
+			if err != nil {
+				return redacted, err
+			}
+		}
+
+		if len(batch) < batchSize {
+			return redacted, nil
+		}
+	}
+}
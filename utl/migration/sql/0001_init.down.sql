@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS payment.rate_limit_buckets;
+
+DROP TABLE IF EXISTS payment.api_keys;
+
+DROP TABLE IF EXISTS payment.status_history;
+
+DROP TABLE IF EXISTS payment.refunds;
+
+DROP TABLE IF EXISTS payment.payment_intents;
+
+DROP TABLE IF EXISTS payment.merchants;
+
+DROP FUNCTION IF EXISTS generate_uid(INT);
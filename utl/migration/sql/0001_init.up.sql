@@ -0,0 +1,121 @@
+-- tables created by the former AutoMigrate, every statement is idempotent so databases it migrated adopt this version.
+-- the columns added since are added to the tables AutoMigrate created. payments and refunds made before merchants keep
+-- a NULL merchant_id, which merchant scoped queries do not return, until they are assigned to the merchant owning the
+-- stripe account they were made with (see the README).
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+CREATE OR REPLACE FUNCTION generate_uid(size INT) RETURNS TEXT AS $$
+DECLARE
+characters TEXT := 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789';
+  bytes BYTEA := gen_random_bytes(size);
+  l INT := length(characters);
+  i INT := 0;
+output TEXT := '';
+BEGIN
+  WHILE i < size LOOP
+    output := output || substr(characters, get_byte(bytes, i) % l + 1, 1);
+    i := i + 1;
+END LOOP;
+  RETURN output;
+END;
+$$ LANGUAGE plpgsql VOLATILE;
+
+CREATE SCHEMA IF NOT EXISTS payment;
+
+CREATE TABLE IF NOT EXISTS payment.merchants (
+    id                     TEXT NOT NULL DEFAULT ('mr_' || generate_uid(12)),
+    name                   TEXT NOT NULL,
+    stripe_secret_key      TEXT NOT NULL,
+    stripe_publishable_key TEXT,
+    created_at             TIMESTAMPTZ,
+    updated_at             TIMESTAMPTZ,
+    deleted_at             TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_merchants_deleted_at ON payment.merchants (deleted_at);
+
+CREATE TABLE IF NOT EXISTS payment.payment_intents (
+    id          TEXT NOT NULL DEFAULT ('pi_' || generate_uid(12)),
+    merchant_id TEXT,
+    amount      BIGINT,
+    provider_id TEXT,
+    email       TEXT,
+    status      TEXT,
+    payload     JSONB,
+    version     BIGINT NOT NULL DEFAULT 1,
+    created_at  TIMESTAMPTZ,
+    updated_at  TIMESTAMPTZ,
+    deleted_at  TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+
+ALTER TABLE payment.payment_intents ADD COLUMN IF NOT EXISTS merchant_id TEXT;
+
+ALTER TABLE payment.payment_intents ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS idx_payment_payment_intents_merchant_id ON payment.payment_intents (merchant_id);
+
+CREATE INDEX IF NOT EXISTS idx_payment_payment_intents_deleted_at ON payment.payment_intents (deleted_at);
+
+CREATE TABLE IF NOT EXISTS payment.refunds (
+    id                TEXT NOT NULL DEFAULT ('rf_' || generate_uid(12)),
+    merchant_id       TEXT,
+    provider_id       TEXT NOT NULL,
+    payment_intent_id TEXT NOT NULL,
+    amount            BIGINT NOT NULL,
+    status            TEXT NOT NULL,
+    created_at        TIMESTAMPTZ,
+    updated_at        TIMESTAMPTZ,
+    deleted_at        TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+
+ALTER TABLE payment.refunds ADD COLUMN IF NOT EXISTS merchant_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_payment_refunds_merchant_id ON payment.refunds (merchant_id);
+
+CREATE INDEX IF NOT EXISTS idx_payment_refunds_deleted_at ON payment.refunds (deleted_at);
+
+CREATE TABLE IF NOT EXISTS payment.status_history (
+    id                TEXT NOT NULL DEFAULT ('sh_' || generate_uid(12)),
+    payment_intent_id TEXT NOT NULL,
+    from_status       TEXT,
+    to_status         TEXT NOT NULL,
+    actor             TEXT NOT NULL,
+    reason            TEXT,
+    created_at        TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_status_history_payment_intent_id ON payment.status_history (payment_intent_id);
+
+CREATE TABLE IF NOT EXISTS payment.api_keys (
+    id         TEXT NOT NULL DEFAULT ('ak_' || generate_uid(12)),
+    client_id  TEXT NOT NULL,
+    name       TEXT,
+    prefix     TEXT NOT NULL,
+    hash       TEXT NOT NULL,
+    scopes     TEXT NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_api_keys_client_id ON payment.api_keys (client_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_api_keys_prefix ON payment.api_keys (prefix);
+
+CREATE INDEX IF NOT EXISTS idx_payment_api_keys_deleted_at ON payment.api_keys (deleted_at);
+
+CREATE TABLE IF NOT EXISTS payment.rate_limit_buckets (
+    key        TEXT NOT NULL,
+    tokens     DECIMAL NOT NULL,
+    allowed    BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ,
+    PRIMARY KEY (key)
+);
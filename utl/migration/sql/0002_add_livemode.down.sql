@@ -0,0 +1,4 @@
+-- drops the livemode column of payment intents.
+DROP INDEX IF EXISTS payment.idx_payment_payment_intents_livemode;
+
+ALTER TABLE payment.payment_intents DROP COLUMN IF EXISTS livemode;
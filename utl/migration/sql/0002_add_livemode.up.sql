@@ -0,0 +1,8 @@
+-- stores whether a payment intent was made with a live key, so test data stays out of live reporting.
+ALTER TABLE payment.payment_intents ADD COLUMN IF NOT EXISTS livemode BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE payment.payment_intents
+SET livemode = true
+WHERE payload->>'livemode' = 'true';
+
+CREATE INDEX IF NOT EXISTS idx_payment_payment_intents_livemode ON payment.payment_intents (livemode, created_at);
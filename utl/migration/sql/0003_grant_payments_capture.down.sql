@@ -0,0 +1,4 @@
+-- takes payments:capture back from keys granted payments:write, which captures again before the scope existed.
+UPDATE payment.api_keys
+SET scopes = array_to_string(array_remove(string_to_array(scopes, ','), 'payments:capture'), ',')
+WHERE 'payments:write' = ANY (string_to_array(scopes, ','));
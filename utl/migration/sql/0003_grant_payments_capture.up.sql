@@ -0,0 +1,5 @@
+-- capturing moved from payments:write to its own payments:capture scope, keys issued before keep capturing.
+UPDATE payment.api_keys
+SET scopes = scopes || ',payments:capture'
+WHERE 'payments:write' = ANY (string_to_array(scopes, ','))
+  AND NOT 'payments:capture' = ANY (string_to_array(scopes, ','));
@@ -0,0 +1,2 @@
+-- drops the payment intent index of refunds.
+DROP INDEX IF EXISTS payment.idx_payment_refunds_payment_intent_id;
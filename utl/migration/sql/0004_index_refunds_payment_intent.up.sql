@@ -0,0 +1,2 @@
+-- indexes refunds by payment intent, a refund looks up the refunds already issued for the intent.
+CREATE INDEX IF NOT EXISTS idx_payment_refunds_payment_intent_id ON payment.refunds (payment_intent_id);
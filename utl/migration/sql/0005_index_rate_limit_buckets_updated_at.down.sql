@@ -0,0 +1,2 @@
+-- drops the last use index of rate limit buckets.
+DROP INDEX IF EXISTS payment.idx_payment_rate_limit_buckets_updated_at;
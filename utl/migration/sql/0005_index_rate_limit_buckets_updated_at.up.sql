@@ -0,0 +1,2 @@
+-- indexes rate limit buckets by their last use, idle buckets are deleted by it.
+CREATE INDEX IF NOT EXISTS idx_payment_rate_limit_buckets_updated_at ON payment.rate_limit_buckets (updated_at);
@@ -0,0 +1,20 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/swagftw/stripe_pay_service/pkg/auth"
+)
+
+type APIKeyMockRepository struct {
+	CreateAPIKeyFn      func(ctx context.Context, key *auth.APIKey) error
+	GetAPIKeyByPrefixFn func(ctx context.Context, prefix string) (*auth.APIKey, error)
+}
+
+func (a APIKeyMockRepository) CreateAPIKey(ctx context.Context, key *auth.APIKey) error {
+	return a.CreateAPIKeyFn(ctx, key)
+}
+
+func (a APIKeyMockRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*auth.APIKey, error) {
+	return a.GetAPIKeyByPrefixFn(ctx, prefix)
+}
@@ -0,0 +1,20 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/swagftw/stripe_pay_service/pkg/merchants"
+)
+
+type MerchantMockRepository struct {
+	CreateMerchantFn func(ctx context.Context, merchant *merchants.Merchant) error
+	GetMerchantFn    func(ctx context.Context, id string) (*merchants.Merchant, error)
+}
+
+func (m MerchantMockRepository) CreateMerchant(ctx context.Context, merchant *merchants.Merchant) error {
+	return m.CreateMerchantFn(ctx, merchant)
+}
+
+func (m MerchantMockRepository) GetMerchant(ctx context.Context, id string) (*merchants.Merchant, error) {
+	return m.GetMerchantFn(ctx, id)
+}
@@ -7,10 +7,13 @@ import (
 )
 
 type PaymentMockRepository struct {
-	CreatePaymentFn func(ctx context.Context, payment *payments.PaymentIntent) error
-	UpdatePaymentFn func(ctx context.Context, payment *payments.PaymentIntent) error
-	GetPaymentFn    func(ctx context.Context, id string) (*payments.PaymentIntent, error)
-	CreateRefundFn  func(ctx context.Context, refund *payments.Refund) (*payments.Refund, error)
+	CreatePaymentFn       func(ctx context.Context, payment *payments.PaymentIntent) error
+	UpdatePaymentFn       func(ctx context.Context, payment *payments.PaymentIntent) error
+	TransitionPaymentFn   func(ctx context.Context, payment *payments.PaymentIntent, next payments.Status, actor, reason string) error
+	GetPaymentFn          func(ctx context.Context, id string) (*payments.PaymentIntent, error)
+	GetPaymentForUpdateFn func(ctx context.Context, id string) (*payments.PaymentIntent, error)
+	CreateRefundFn        func(ctx context.Context, refund *payments.Refund) (*payments.Refund, error)
+	GetRefundsFn          func(ctx context.Context, paymentIntentID string) ([]*payments.Refund, error)
 }
 
 func (p PaymentMockRepository) CreatePayment(ctx context.Context, payment *payments.PaymentIntent) error {
@@ -21,10 +24,22 @@ func (p PaymentMockRepository) UpdatePayment(ctx context.Context, payment *payme
 	return p.UpdatePaymentFn(ctx, payment)
 }
 
+func (p PaymentMockRepository) TransitionPayment(ctx context.Context, payment *payments.PaymentIntent, next payments.Status, actor, reason string) error {
+	return p.TransitionPaymentFn(ctx, payment, next, actor, reason)
+}
+
 func (p PaymentMockRepository) GetPayment(ctx context.Context, id string) (*payments.PaymentIntent, error) {
 	return p.GetPaymentFn(ctx, id)
 }
 
+func (p PaymentMockRepository) GetPaymentForUpdate(ctx context.Context, id string) (*payments.PaymentIntent, error) {
+	return p.GetPaymentForUpdateFn(ctx, id)
+}
+
 func (p PaymentMockRepository) CreateRefund(ctx context.Context, refund *payments.Refund) (*payments.Refund, error) {
 	return p.CreateRefundFn(ctx, refund)
 }
+
+func (p PaymentMockRepository) GetRefunds(ctx context.Context, paymentIntentID string) ([]*payments.Refund, error) {
+	return p.GetRefundsFn(ctx, paymentIntentID)
+}
@@ -0,0 +1,30 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/swagftw/stripe_pay_service/types"
+)
+
+type StripeMockService struct {
+	CreatePaymentIntentFn  func(ctx context.Context, req *types.CreateIntentReq) (*types.CreateIntentRes, error)
+	CapturePaymentIntentFn func(ctx context.Context, paymentID string, amount int) (*types.CaptureIntentRes, error)
+	GetAllPaymentIntentsFn func(ctx context.Context) ([]*types.PaymentIntent, error)
+	CreateRefundFn         func(ctx context.Context, paymentID string, amount int) (*types.CreateRefundRes, error)
+}
+
+func (s StripeMockService) CreatePaymentIntent(ctx context.Context, req *types.CreateIntentReq) (*types.CreateIntentRes, error) {
+	return s.CreatePaymentIntentFn(ctx, req)
+}
+
+func (s StripeMockService) CapturePaymentIntent(ctx context.Context, paymentID string, amount int) (*types.CaptureIntentRes, error) {
+	return s.CapturePaymentIntentFn(ctx, paymentID, amount)
+}
+
+func (s StripeMockService) GetAllPaymentIntents(ctx context.Context) ([]*types.PaymentIntent, error) {
+	return s.GetAllPaymentIntentsFn(ctx)
+}
+
+func (s StripeMockService) CreateRefund(ctx context.Context, paymentID string, amount int) (*types.CreateRefundRes, error) {
+	return s.CreateRefundFn(ctx, paymentID, amount)
+}
@@ -0,0 +1,137 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Generator derives schemas from go types, so documents follow the types they describe.
+// named structs are added to the components and referred to, anonymous ones are inlined.
+type Generator struct {
+	schemas map[string]*Schema
+	rules   map[string]func(s *Schema)
+}
+
+// NewGenerator returns a generator knowing the validate rules of go-playground/validator changing the schema
+// of a field, required is known to every generator.
+func NewGenerator() *Generator {
+	g := &Generator{
+		schemas: make(map[string]*Schema),
+		rules:   make(map[string]func(s *Schema)),
+	}
+
+	g.Rule("email", func(s *Schema) { s.Format = "email" })
+	g.Rule("e164", func(s *Schema) { s.Pattern = `^\+[1-9][0-9]{1,14}$` })
+
+	return g
+}
+
+// Rule sets how the schema of a field validated by tag is described, such as custom validations.
+func (g *Generator) Rule(tag string, apply func(s *Schema)) {
+	g.rules[tag] = apply
+}
+
+// Schema returns the schema of the type of v.
+func (g *Generator) Schema(v interface{}) *Schema {
+	return g.schemaOf(reflect.TypeOf(v))
+}
+
+// Schemas returns the schemas of the named structs met so far, by type name.
+func (g *Generator) Schemas() map[string]*Schema {
+	return g.schemas
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *Generator) schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// registered before the fields are walked, so recursive types end
+			g.schemas[t.Name()] = &Schema{}
+			*g.schemas[t.Name()] = *g.structSchema(t)
+		}
+
+		return Ref(t.Name())
+	default:
+		// interfaces may hold any value
+		return &Schema{}
+	}
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+
+	g.addFields(s, t)
+
+	return s
+}
+
+// addFields adds the json fields of t to s, the fields of embedded structs are promoted.
+func (g *Generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			g.addFields(s, field.Type)
+
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldSchema := g.schemaOf(field.Type)
+
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "required" {
+				s.Required = append(s.Required, name)
+
+				continue
+			}
+
+			if apply, ok := g.rules[rule]; ok {
+				apply(fieldSchema)
+			}
+		}
+
+		s.Properties[name] = fieldSchema
+	}
+}
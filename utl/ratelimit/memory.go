@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets are dropped from memory.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	rule    Rule
+}
+
+type memoryStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore returns a store keeping buckets in memory, limits then apply per instance.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take takes a token from the bucket under key.
+func (m *memoryStore) Take(_ context.Context, key string, rule Rule) (Result, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), updated: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = refill(b.tokens, now.Sub(b.updated), rule)
+	b.updated = now
+	b.rule = rule
+
+	if b.tokens < 1 {
+		return result(false, b.tokens, rule), nil
+	}
+
+	b.tokens--
+
+	return result(true, b.tokens, rule), nil
+}
+
+// sweep drops buckets which refilled completely, they are recreated full on the next request anyway.
+func (m *memoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		if refill(b.tokens, now.Sub(b.updated), b.rule) >= float64(b.rule.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+)
+
+// Bucket is the db model for a token bucket shared by all instances.
+type Bucket struct {
+	Key       string  `gorm:"primaryKey"`
+	Tokens    float64 `gorm:"not null"`
+	Allowed   bool    `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+func (*Bucket) TableName() string {
+	return "payment.rate_limit_buckets"
+}
+
+// takeQuery refills the bucket and takes a token in a single statement, so concurrent instances never race.
+const takeQuery = `
+INSERT INTO payment.rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES (@key, @burst - 1, true, now())
+ON CONFLICT (key) DO UPDATE SET
+	allowed = LEAST(@burst, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * @rate) >= 1,
+	tokens = LEAST(@burst, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * @rate)
+		- CASE WHEN LEAST(@burst, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * @rate) >= 1 THEN 1 ELSE 0 END,
+	updated_at = now()
+RETURNING tokens, allowed`
+
+// purgeQuery deletes the buckets idle longer than window seconds, measured on the database clock like updated_at.
+const purgeQuery = `DELETE FROM payment.rate_limit_buckets WHERE updated_at < now() - @window * interval '1 second'`
+
+type postgresStore struct {
+	db        *gorm.DB
+	mutex     sync.Mutex
+	lastSweep time.Time
+	// window is the longest time a rule seen so far takes to refill an empty bucket.
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewPostgresStore returns a store keeping buckets in postgres, limits then apply across all instances.
+// every instance deletes idle buckets once per sweepInterval, so the table only holds recently used keys.
+func NewPostgresStore(db *gorm.DB) Store {
+	return &postgresStore{
+		db:        db,
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Take takes a token from the bucket under key.
+func (p *postgresStore) Take(ctx context.Context, key string, rule Rule) (Result, error) {
+	p.sweep(ctx, rule)
+
+	var row Bucket
+
+	err := p.db.WithContext(ctx).Raw(takeQuery, map[string]interface{}{
+		"key":   key,
+		"burst": rule.Burst,
+		"rate":  rule.Rate,
+	}).Scan(&row).Error
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result(row.Allowed, row.Tokens, rule), nil
+}
+
+// sweep deletes buckets idle longer than the longest refill window seen, they refilled completely and are recreated
+// full on the next request anyway. the first sweep waits a sweepInterval so that the rules in use were seen.
+// a failed sweep only leaves the rows for the next one, the request is not failed for it.
+func (p *postgresStore) sweep(ctx context.Context, rule Rule) {
+	p.mutex.Lock()
+
+	if window := refillWindow(rule); window > p.window {
+		p.window = window
+	}
+
+	now := p.now()
+	if now.Sub(p.lastSweep) < sweepInterval {
+		p.mutex.Unlock()
+
+		return
+	}
+
+	p.lastSweep = now
+	window := p.window
+
+	p.mutex.Unlock()
+
+	err := p.db.WithContext(ctx).Exec(purgeQuery, map[string]interface{}{"window": window.Seconds()}).Error
+	if err != nil {
+		logger.Logger.Error(ctx, "error deleting idle rate limit buckets", err)
+	}
+}
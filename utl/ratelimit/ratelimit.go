@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Rule is a token bucket: it holds up to Burst tokens and refills at Rate tokens per second.
+type Rule struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the state of a bucket after a request took (or failed to take) a token.
+type Result struct {
+	Allowed bool
+	Limit   int
+	// Remaining is the number of whole tokens left.
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next token is available, zero when one is available now.
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets.
+type Store interface {
+	// Take takes a token from the bucket under key.
+	Take(ctx context.Context, key string, rule Rule) (Result, error)
+}
+
+// refill returns the tokens in a bucket which had tokens at the last update, elapsed ago.
+func refill(tokens float64, elapsed time.Duration, rule Rule) float64 {
+	return math.Min(float64(rule.Burst), tokens+elapsed.Seconds()*rule.Rate)
+}
+
+// refillWindow returns the time an empty bucket of rule takes to fill up completely.
+func refillWindow(rule Rule) time.Duration {
+	return seconds(float64(rule.Burst) / rule.Rate)
+}
+
+// result describes the bucket holding tokens after the request was allowed or denied.
+func result(allowed bool, tokens float64, rule Rule) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     rule.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(rule.Burst) - tokens) / rule.Rate),
+	}
+
+	if tokens < 1 {
+		res.RetryAfter = seconds((1 - tokens) / rule.Rate)
+	}
+
+	return res
+}
+
+func seconds(val float64) time.Duration {
+	if val <= 0 {
+		return 0
+	}
+
+	return time.Duration(val * float64(time.Second))
+}
@@ -0,0 +1,227 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Mask replaces every redacted value.
+const Mask = "[REDACTED]"
+
+// DefaultPaths are the secrets and PII stripe returns on a payment intent.
+// a path is a dot separated list of keys, "*" matches any key and "**" any number of keys.
+// arrays are walked through, so charges.data.billing_details masks the billing details of every charge.
+var DefaultPaths = []string{
+	"**.client_secret",
+	"**.receipt_email",
+	"**.receipt_url",
+	"**.billing_details",
+	"**.shipping",
+	"**.card.fingerprint",
+	"**.card.exp_month",
+	"**.card.exp_year",
+	"**.card.number",
+	"**.card.cvc",
+	"**.password",
+	"**.secret_key",
+}
+
+// patterns match secrets and PII inside free text, such as error messages and logged sql.
+var patterns = []*regexp.Regexp{
+	regexp.MustCompile(`pi_[A-Za-z0-9]+_secret_[A-Za-z0-9]+`),
+	regexp.MustCompile(`\b(sk|rk)_(live|test)_[A-Za-z0-9]+`),
+	regexp.MustCompile(`\bwhsec_[A-Za-z0-9]+`),
+	regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+}
+
+// paths holds the parsed paths of the running redactor, replaced as a whole by Configure.
+var paths atomic.Value
+
+func init() {
+	Configure(nil)
+}
+
+// Configure sets the field paths masked by JSON and Value, DefaultPaths are used when none are given.
+func Configure(fieldPaths []string) {
+	if len(fieldPaths) == 0 {
+		fieldPaths = DefaultPaths
+	}
+
+	parsed := make([][]string, 0, len(fieldPaths))
+	for _, p := range fieldPaths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		parsed = append(parsed, strings.Split(p, "."))
+	}
+
+	paths.Store(parsed)
+}
+
+// JSON masks the configured paths of a json document and the secrets found in its strings.
+// nulls are left alone so that a missing value stays distinguishable from a redacted one.
+func JSON(data []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return data, nil
+	}
+
+	doc, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(redact(doc))
+}
+
+// JSONChanged masks a json document like JSON and reports whether masking changed it. the documents are compared
+// decoded, so an already masked document stored with other spacing or key order, as jsonb is, is unchanged.
+func JSONChanged(data []byte) ([]byte, bool, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return data, false, nil
+	}
+
+	doc, err := decode(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// masking works in place, the document is encoded before
+	original, err := json.Marshal(doc)
+	if err != nil {
+		return nil, false, err
+	}
+
+	redacted, err := json.Marshal(redact(doc))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return redacted, !bytes.Equal(original, redacted), nil
+}
+
+// Value returns v with the configured paths and secrets masked, ready to be logged.
+// values that cannot be encoded as json are logged as their masked text.
+func Value(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return String(fmt.Sprint(v))
+	}
+
+	doc, err := decode(data)
+	if err != nil {
+		return String(string(data))
+	}
+
+	return redact(doc)
+}
+
+// decode decodes a json document keeping numbers as they were written.
+func decode(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var doc interface{}
+
+	err := decoder.Decode(&doc)
+
+	return doc, err
+}
+
+// String masks the secrets and emails found in s.
+func String(s string) string {
+	for _, pattern := range patterns {
+		s = pattern.ReplaceAllString(s, Mask)
+	}
+
+	return s
+}
+
+func redact(doc interface{}) interface{} {
+	for _, path := range paths.Load().([][]string) {
+		doc = walk(doc, path)
+	}
+
+	return scrub(doc)
+}
+
+// walk masks the values of doc found at path.
+func walk(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return mask(v)
+	}
+
+	switch node := v.(type) {
+	case map[string]interface{}:
+		key := path[0]
+
+		if key == "**" {
+			// "**" matches no key at all, or any key while staying in place for the next level
+			v = walk(node, path[1:])
+			if node, ok := v.(map[string]interface{}); ok {
+				for k, child := range node {
+					node[k] = walk(child, path)
+				}
+			}
+
+			return v
+		}
+
+		for k, child := range node {
+			if key == "*" || key == k {
+				node[k] = walk(child, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, child := range node {
+			node[i] = walk(child, path)
+		}
+	}
+
+	return v
+}
+
+// mask replaces every value under v, keeping the shape of objects and arrays.
+func mask(v interface{}) interface{} {
+	switch node := v.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		for k, child := range node {
+			node[k] = mask(child)
+		}
+
+		return node
+	case []interface{}:
+		for i, child := range node {
+			node[i] = mask(child)
+		}
+
+		return node
+	default:
+		return Mask
+	}
+}
+
+// scrub masks the secrets found in the strings under v.
+func scrub(v interface{}) interface{} {
+	switch node := v.(type) {
+	case string:
+		return String(node)
+	case map[string]interface{}:
+		for k, child := range node {
+			node[k] = scrub(child)
+		}
+	case []interface{}:
+		for i, child := range node {
+			node[i] = scrub(child)
+		}
+	}
+
+	return v
+}
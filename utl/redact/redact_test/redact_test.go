@@ -0,0 +1,139 @@
+package redact_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/stripe_pay_service/utl/redact"
+)
+
+func TestJSON(t *testing.T) {
+	defer redact.Configure(nil)
+
+	cases := []struct {
+		name  string
+		paths []string
+		in    string
+		want  string
+	}{
+		{
+			name: "payment intent",
+			in:   `{"id":"pi_1","amount":2000,"client_secret":"pi_1_secret_abc","receipt_email":"jane@example.com","customer":null}`,
+			want: `{"amount":2000,"client_secret":"[REDACTED]","customer":null,"id":"pi_1","receipt_email":"[REDACTED]"}`,
+		},
+		{
+			name: "nested charges",
+			in:   `{"charges":{"data":[{"billing_details":{"name":"Jane","phone":null},"payment_method_details":{"card":{"fingerprint":"fp","last4":"4242"}}}]}}`,
+			want: `{"charges":{"data":[{"billing_details":{"name":"[REDACTED]","phone":null},"payment_method_details":{"card":{"fingerprint":"[REDACTED]","last4":"4242"}}}]}}`,
+		},
+		{
+			name: "secret in free text",
+			in:   `{"description":"order for jane@example.com","last_payment_error":{"message":"key sk_live_abc123 is invalid"}}`,
+			want: `{"description":"order for [REDACTED]","last_payment_error":{"message":"key [REDACTED] is invalid"}}`,
+		},
+		{
+			name:  "configured paths",
+			paths: []string{"metadata.*", "shipping.name"},
+			in:    `{"metadata":{"order":"42"},"shipping":{"name":"Jane","carrier":"ups"},"client_secret":"pi_1_secret_abc"}`,
+			want:  `{"client_secret":"[REDACTED]","metadata":{"order":"[REDACTED]"},"shipping":{"carrier":"ups","name":"[REDACTED]"}}`,
+		},
+		{
+			name: "large numbers kept",
+			in:   `{"amount":12345678901234567890}`,
+			want: `{"amount":12345678901234567890}`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			redact.Configure(tt.paths)
+
+			got, err := redact.JSON([]byte(tt.in))
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestJSONChanged(t *testing.T) {
+	cases := []struct {
+		name        string
+		in          string
+		wantChanged bool
+	}{
+		{
+			name:        "secrets to mask",
+			in:          `{"id": "pi_1", "client_secret": "pi_1_secret_abc", "amount": 2000}`,
+			wantChanged: true,
+		},
+		{
+			// jsonb output, spaced and ordered by key length
+			name: "already masked as stored in jsonb",
+			in:   `{"id": "pi_1", "amount": 2000, "client_secret": "[REDACTED]", "receipt_email": "[REDACTED]", "charges": {"data": [{"billing_details": {"name": "[REDACTED]", "phone": null}}]}}`,
+		},
+		{
+			name: "nothing to mask",
+			in:   `{"id": "pi_1", "amount": 12345678901234567890, "customer": null}`,
+		},
+		{
+			name: "empty",
+			in:   "",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed, err := redact.JSONChanged([]byte(tt.in))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantChanged, changed)
+
+			if tt.in != "" {
+				assert.NotContains(t, string(got), "pi_1_secret_abc")
+			}
+		})
+	}
+}
+
+func TestJSONInvalid(t *testing.T) {
+	_, err := redact.JSON([]byte(`{"client_secret":`))
+	assert.Error(t, err)
+}
+
+func TestValue(t *testing.T) {
+	params := []interface{}{map[string]interface{}{"receipt_email": "jane@example.com", "amount": 100}}
+
+	got, err := json.Marshal(redact.Value(params))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.JSONEq(t, `[{"amount":100,"receipt_email":"[REDACTED]"}]`, string(got))
+
+	// values json can not encode are masked as text
+	unencodable := fmt.Sprint(redact.Value([]interface{}{make(chan int), "jane@example.com"}))
+	assert.Contains(t, unencodable, redact.Mask)
+	assert.NotContains(t, unencodable, "jane@example.com")
+}
+
+func TestString(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "client secret", in: "confirm pi_3Kx_secret_Yz9 failed", want: "confirm [REDACTED] failed"},
+		{name: "secret key", in: errors.New("invalid api key sk_test_4eC39H").Error(), want: "invalid api key [REDACTED]"},
+		{name: "email", in: `INSERT INTO "payment"."payment_intents" ("email") VALUES ('jane.doe+1@example.co.uk')`, want: `INSERT INTO "payment"."payment_intents" ("email") VALUES ('[REDACTED]')`},
+		{name: "nothing to mask", in: "payment intent pi_1 not found", want: "payment intent pi_1 not found"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, redact.String(tt.in))
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a provider holds no secret for a reference.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider fetches secrets by reference, the form of the reference depends on the provider.
+type Provider interface {
+	Get(ctx context.Context, ref string) (string, error)
+}
+
+// env provides secrets from environment variables, the reference is the variable name.
+type env struct{}
+
+// NewEnv returns a provider reading secrets from environment variables.
+func NewEnv() Provider {
+	return env{}
+}
+
+func (env) Get(_ context.Context, ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("env %s: %w", ref, ErrNotFound)
+	}
+
+	return strings.TrimSpace(value), nil
+}
+
+// file provides secrets from files, the reference is a path relative to the directory.
+// mounted kubernetes secrets and docker secrets are files of this kind.
+type file struct {
+	dir string
+}
+
+// NewFile returns a provider reading secrets from the files in dir.
+func NewFile(dir string) Provider {
+	return file{dir: dir}
+}
+
+func (f file) Get(_ context.Context, ref string) (string, error) {
+	path := ref
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(f.dir, path)
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("file %s: %w", path, ErrNotFound)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// VaultConfig locates a HashiCorp Vault KV version 2 secrets engine.
+type VaultConfig struct {
+	Address   string
+	Token     string
+	Mount     string
+	Namespace string
+	Timeout   time.Duration
+}
+
+// vault provides secrets from a Vault KV version 2 engine, the reference is the secret path and the field
+// separated by "#", such as stripe#secret_key. the latest version of the secret is read.
+type vault struct {
+	cfg    VaultConfig
+	client *http.Client
+}
+
+// NewVault returns a provider reading secrets from vault over its http api.
+func NewVault(cfg VaultConfig) Provider {
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	return &vault{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// vaultResponse is the part of a KV version 2 read used, or the errors vault answered with.
+type vaultResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (v *vault) Get(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("vault reference %q is not <path>#<field>", ref)
+	}
+
+	endpoint := strings.TrimRight(v.cfg.Address, "/") + "/v1/" + strings.Trim(v.cfg.Mount, "/") + "/data/" + strings.TrimLeft(path, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("X-Vault-Token", v.cfg.Token)
+
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault %s: %w", path, err)
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("vault %s: %w", path, err)
+	}
+
+	var vaultRes vaultResponse
+
+	// error answers may not be json, their status tells enough
+	_ = json.Unmarshal(body, &vaultRes)
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("vault %s: %w", path, ErrNotFound)
+	case res.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("vault %s answered %s: %s", path, res.Status, strings.Join(vaultRes.Errors, ", "))
+	}
+
+	value, ok := vaultRes.Data.Data[field]
+	if !ok {
+		return "", fmt.Errorf("vault %s field %s: %w", path, field, ErrNotFound)
+	}
+
+	secret, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("vault %s field %s is not a string", path, field)
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,158 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+)
+
+// binding fetches the secret at ref into a config setting.
+type binding struct {
+	name  string
+	ref   string
+	set   func(string)
+	value string
+}
+
+// Manager fetches the secrets referenced by the config from a provider and writes them into the config.
+type Manager struct {
+	provider Provider
+	interval time.Duration
+	mutex    sync.Mutex
+	bindings []*binding
+}
+
+var manager *Manager
+
+// NewManager returns a manager fetching the secrets referenced in the secrets config of cfg from provider.
+func NewManager(cfg *config.GlobalConfig, provider Provider) *Manager {
+	secretsCfg := cfg.GetSecretsConfig()
+
+	m := &Manager{
+		provider: provider,
+		interval: time.Duration(secretsCfg.RefreshInterval) * time.Second,
+	}
+
+	refs := []*binding{
+		{name: "stripe secret key", ref: secretsCfg.StripeSecretKey, set: cfg.SetStripeSecretKey},
+		{name: "database password", ref: secretsCfg.DBPassword, set: cfg.SetDBPassword},
+	}
+
+	for _, b := range refs {
+		if b.ref != "" {
+			m.bindings = append(m.bindings, b)
+		}
+	}
+
+	return m
+}
+
+// NewProvider returns the provider named in the secrets config, nil when none is.
+func NewProvider(cfg *config.Secrets) (Provider, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "env":
+		return NewEnv(), nil
+	case "file":
+		return NewFile(cfg.Dir), nil
+	case "vault":
+		return NewVault(VaultConfig{
+			Address:   cfg.Vault.Address,
+			Token:     cfg.Vault.Token,
+			Mount:     cfg.Vault.Mount,
+			Namespace: cfg.Vault.Namespace,
+		}), nil
+	}
+
+	return nil, fmt.Errorf("unknown secrets provider %q", cfg.Provider)
+}
+
+// Init fetches the secrets referenced by the global config into it, it is called once the config is loaded.
+// nothing is fetched when no provider is configured.
+func Init(ctx context.Context) error {
+	cfg := config.GetGlobalConfig()
+
+	provider, err := NewProvider(cfg.GetSecretsConfig())
+	if err != nil || provider == nil {
+		return err
+	}
+
+	m := NewManager(cfg, provider)
+
+	_, err = m.Refresh(ctx)
+	if err != nil {
+		return err
+	}
+
+	manager = m
+
+	return nil
+}
+
+// Watch fetches the secrets again every refresh interval until ctx is done, so that rotated secrets are picked up.
+func Watch(ctx context.Context) {
+	if manager != nil {
+		manager.Watch(ctx)
+	}
+}
+
+// Refresh fetches every referenced secret and sets the ones which changed, it returns their names.
+// secrets fetched before an error are kept.
+func (m *Manager) Refresh(ctx context.Context) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var changed []string
+
+	for _, b := range m.bindings {
+		value, err := m.provider.Get(ctx, b.ref)
+		if err != nil {
+			return changed, fmt.Errorf("fetching %s: %w", b.name, err)
+		}
+
+		if value == b.value {
+			continue
+		}
+
+		b.set(value)
+		b.value = value
+
+		changed = append(changed, b.name)
+	}
+
+	return changed, nil
+}
+
+// Watch refreshes the secrets every refresh interval until ctx is done, it returns at once when the interval is zero.
+func (m *Manager) Watch(ctx context.Context) {
+	if m.interval <= 0 || len(m.bindings) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				changed, err := m.Refresh(ctx)
+				if err != nil {
+					// the secrets in use stay until the provider answers again
+					logger.Logger.Error(ctx, "error refreshing secrets", err)
+				}
+
+				for _, name := range changed {
+					logger.Logger.Info(ctx, "secret rotated", name)
+				}
+			}
+		}
+	}()
+}
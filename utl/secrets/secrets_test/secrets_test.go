@@ -0,0 +1,182 @@
+package secrets_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/secrets"
+)
+
+// encryptionKeyOverride sets the encryption key the config requires, .env leaves it empty.
+const encryptionKeyOverride = "security.encryptionKey=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+// vaultStub answers KV version 2 reads from secrets, which maps secret paths to their fields.
+type vaultStub struct {
+	mutex   sync.Mutex
+	token   string
+	secrets map[string]map[string]interface{}
+}
+
+func (v *vaultStub) set(path, field string, value interface{}) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.secrets[path][field] = value
+}
+
+func (v *vaultStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != v.token {
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+
+		return
+	}
+
+	data, ok := v.secrets[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{}})
+
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{
+			"data":     data,
+			"metadata": map[string]interface{}{"version": 1},
+		},
+	})
+}
+
+func newVaultStub(t *testing.T) (*vaultStub, *httptest.Server) {
+	t.Helper()
+
+	stub := &vaultStub{
+		token: "root",
+		secrets: map[string]map[string]interface{}{
+			"/v1/secret/data/stripe":   {"secret_key": "rk_test_1", "version": 3},
+			"/v1/secret/data/database": {"password": "db_1"},
+		},
+	}
+
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	return stub, srv
+}
+
+func TestVault(t *testing.T) {
+	_, srv := newVaultStub(t)
+
+	cases := []struct {
+		name     string
+		token    string
+		ref      string
+		want     string
+		wantErr  string
+		notFound bool
+	}{
+		{name: "field", token: "root", ref: "stripe#secret_key", want: "rk_test_1"},
+		{name: "missing secret", token: "root", ref: "nothing#secret_key", notFound: true},
+		{name: "missing field", token: "root", ref: "stripe#password", notFound: true},
+		{name: "not a string", token: "root", ref: "stripe#version", wantErr: "not a string"},
+		{name: "no field", token: "root", ref: "stripe", wantErr: "<path>#<field>"},
+		{name: "denied", token: "wrong", ref: "stripe#secret_key", wantErr: "permission denied"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := secrets.NewVault(secrets.VaultConfig{Address: srv.URL, Token: tt.token})
+
+			got, err := provider.Get(context.Background(), tt.ref)
+
+			switch {
+			case tt.notFound:
+				assert.ErrorIs(t, err, secrets.ErrNotFound)
+			case tt.wantErr != "":
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnvAndFile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stripe_key"), []byte("rk_test_file\n"), 0o600))
+
+	t.Setenv("STRIPE_KEY_SECRET", " rk_test_env ")
+
+	got, err := secrets.NewEnv().Get(context.Background(), "STRIPE_KEY_SECRET")
+	require.NoError(t, err)
+	assert.Equal(t, "rk_test_env", got)
+
+	_, err = secrets.NewEnv().Get(context.Background(), "NO_SUCH_SECRET")
+	assert.ErrorIs(t, err, secrets.ErrNotFound)
+
+	got, err = secrets.NewFile(dir).Get(context.Background(), "stripe_key")
+	require.NoError(t, err)
+	assert.Equal(t, "rk_test_file", got)
+
+	_, err = secrets.NewFile(dir).Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, secrets.ErrNotFound)
+}
+
+func TestInitAndRotation(t *testing.T) {
+	logger.InitLogger()
+
+	stub, srv := newVaultStub(t)
+
+	t.Setenv("SECRETS_PROVIDER", "vault")
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+	t.Setenv("SECRETS_STRIPE_SECRET_KEY", "stripe#secret_key")
+	t.Setenv("SECRETS_DB_PASSWORD", "database#password")
+
+	require.NoError(t, config.InitConfig("../../config/config.local.yaml", "", encryptionKeyOverride))
+	require.NoError(t, secrets.Init(context.Background()))
+
+	cfg := config.GetGlobalConfig()
+	assert.Equal(t, "rk_test_1", cfg.GetStripeSecretKey())
+	assert.Equal(t, "db_1", cfg.GetDBPassword())
+
+	provider, err := secrets.NewProvider(cfg.GetSecretsConfig())
+	require.NoError(t, err)
+
+	manager := secrets.NewManager(cfg, provider)
+
+	_, err = manager.Refresh(context.Background())
+	require.NoError(t, err)
+
+	stub.set("/v1/secret/data/stripe", "secret_key", "rk_test_2")
+
+	changed, err := manager.Refresh(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"stripe secret key"}, changed)
+	assert.Equal(t, "rk_test_2", cfg.GetStripeSecretKey())
+	assert.Equal(t, "db_1", cfg.GetDBPassword())
+
+	// the secrets in use are kept while the provider fails
+	stub.set("/v1/secret/data/database", "password", 42)
+
+	_, err = manager.Refresh(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "db_1", cfg.GetDBPassword())
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+)
+
+// APIKeyAuth authenticates requests with the api key from the Authorization header
+// and puts the resolved principal on the request context.
+// requests already authenticated by JWTAuth are let through.
+func APIKeyAuth(authService types.AuthService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, ok := types.PrincipalFromContext(c.Request().Context()); ok {
+				return next(c)
+			}
+
+			apiKey, err := bearerToken(c)
+			if err != nil {
+				return err
+			}
+
+			principal, err := authService.Authenticate(ToGoContext(c), apiKey)
+			if err != nil {
+				return err
+			}
+
+			setPrincipal(c, principal)
+
+			return next(c)
+		}
+	}
+}
+
+// RequireScope rejects requests whose principal was not granted the scope.
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok := types.PrincipalFromContext(c.Request().Context())
+			if !ok {
+				return fault.ErrUnauthorized.New("auth", "provide a valid api key in the Authorization header", errors.New("request is not authenticated"))
+			}
+
+			if !principal.HasScope(scope) {
+				return fault.ErrForbidden.New("auth", "credentials are missing the "+scope+" scope", errors.New("missing scope "+scope))
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// bearerToken returns the token from an "Authorization: Bearer <token>" header.
+func bearerToken(c echo.Context) (string, error) {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", fault.ErrUnauthorized.New("auth", "provide a valid api key in the Authorization header", errors.New("missing bearer token"))
+	}
+
+	return strings.TrimSpace(token), nil
+}
+
+func setPrincipal(c echo.Context, principal *types.Principal) {
+	c.SetRequest(c.Request().WithContext(types.WithPrincipal(c.Request().Context(), principal)))
+}
@@ -1,58 +1,120 @@
 package server
 
 import (
-	"context"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 
 	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/config"
 	"github.com/swagftw/stripe_pay_service/utl/constant"
 	"github.com/swagftw/stripe_pay_service/utl/fault"
 	"github.com/swagftw/stripe_pay_service/utl/logger"
 )
 
 // ErrorHandler is a custom echo error handler.
+// every error is answered with a code of the fault catalog and its user message,
+// the text of the underlying error is only sent in debug mode and server errors are logged instead.
+// errors are rendered as rfc 7807 problem documents or in the error envelope, see problemRequested.
+// wrapped errors are answered like the error they wrap.
 func ErrorHandler(err error, ctx echo.Context) {
-	var errResp fault.ErrResponse
-	errResp.StatusCode = http.StatusInternalServerError
-	errResp.Message = "Internal Server Error"
-	errResp.Err = err.Error()
-	errResp.Res = constant.TryAgainLater
-
-	switch e := err.(type) {
-	case *fault.HTTPError:
-		errResp.StatusCode = e.Status
-		errResp.Message = e.Message
-		errResp.Res = e.Res
-		errResp.Service = e.Service
-
-		if e.Err != nil {
-			errResp.Err = e.Err.Error()
+	errResp := kindResponse(fault.ErrInternal, constant.TryAgainLater)
+
+	var (
+		httpErr        *fault.HTTPError
+		echoErr        *echo.HTTPError
+		validationErrs validator.ValidationErrors
+	)
+
+	switch {
+	case errors.As(err, &httpErr):
+		errResp.StatusCode = httpErr.Status
+		errResp.Code = httpErr.ErrCode
+		errResp.Message = httpErr.Message
+		errResp.Res = httpErr.Res
+		errResp.Service = httpErr.Service
+		errResp.Retryable = httpErr.Retryable
+		errResp.Details = httpErr.Details
+		errResp.Fields = httpErr.Fields
+
+		if errResp.Code == "" {
+			errResp.Code = fault.ForStatus(httpErr.Status).Code
 		}
-	case *echo.HTTPError:
-		errResp.StatusCode = e.Code
-		errResp.Message = e.Message
-		errResp.Res = constant.TryAgainLater
-		errResp.Err = e.Error()
-	case *validator.ValidationErrors:
-		errResp.StatusCode = http.StatusBadRequest
-		errResp.Message = "Bad Request"
-		errResp.Err = e.Error()
-		errResp.Res = "Validation Error"
-	case types.CopyError:
-		errResp.StatusCode = http.StatusInternalServerError
-		errResp.Message = "Internal Server Error"
-		errResp.Err = e.Error()
-		errResp.Res = constant.TryAgainLater
+	case errors.As(err, &echoErr):
+		errResp = kindResponse(fault.ForStatus(echoErr.Code), constant.TryAgainLater)
+		errResp.StatusCode = echoErr.Code
+	case errors.As(err, &validationErrs):
+		errResp = kindResponse(fault.ErrValidation, "fix the invalid fields")
+		errResp.Fields = fieldErrors(validationErrs)
+	}
+
+	if errResp.StatusCode >= http.StatusInternalServerError {
+		logger.Logger.Error(ctx.Request().Context(), "error handling request", err, errResp.Code)
+	}
+
+	if DebugMode() {
+		errResp.Err = err.Error()
 	}
 
 	if !ctx.Response().Committed {
-		err := ctx.JSON(errResp.StatusCode, map[string]interface{}{
-			"error": errResp,
-		})
+		err := sendError(ctx, errResp)
+		if err != nil {
+			logger.Logger.Error(ctx.Request().Context(), "error sending response from echo error handler", err)
+		}
+	}
+}
+
+func sendError(ctx echo.Context, errResp fault.ErrResponse) error {
+	ctx.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
 
-		logger.Logger.Error(context.TODO(), "error sending response from echo error handler", err)
+	if !problemRequested(ctx) {
+		return ctx.JSON(errResp.StatusCode, fault.Envelope{Error: errResp})
 	}
+
+	requestID, ok := types.RequestIDFromContext(ctx.Request().Context())
+	if !ok {
+		requestID = ctx.Response().Header().Get(echo.HeaderXRequestID)
+	}
+
+	// echo keeps a content type set before writing json
+	ctx.Response().Header().Set(echo.HeaderContentType, fault.MIMEProblemJSON)
+
+	return ctx.JSON(errResp.StatusCode, errResp.Problem(requestID))
+}
+
+// problemRequested tells whether the error is rendered as a problem document. the first of
+// application/problem+json and application/json named in Accept wins, server.errorFormat decides otherwise.
+func problemRequested(ctx echo.Context) bool {
+	for _, accepted := range strings.Split(ctx.Request().Header.Get(echo.HeaderAccept), ",") {
+		switch strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0]) {
+		case fault.MIMEProblemJSON:
+			return true
+		case echo.MIMEApplicationJSON:
+			return false
+		}
+	}
+
+	cfg := config.GetGlobalConfig()
+
+	return cfg != nil && cfg.GetServerConfig().ErrorFormat == config.ErrorFormatProblem
+}
+
+func kindResponse(kind *fault.Kind, res string) fault.ErrResponse {
+	return fault.ErrResponse{
+		StatusCode: kind.Status,
+		Code:       kind.Code,
+		Message:    kind.Message,
+		Res:        res,
+		Retryable:  kind.Retryable,
+	}
+}
+
+// DebugMode tells whether internal error text may be sent to clients.
+func DebugMode() bool {
+	cfg := config.GetGlobalConfig()
+
+	return cfg != nil && cfg.GetServerConfig().Debug
 }
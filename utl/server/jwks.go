@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksTTL is how long fetched keys are trusted before they are fetched again.
+	jwksTTL = time.Hour
+	// jwksMinRefresh limits refetching when tokens carry unknown key ids.
+	jwksMinRefresh = time.Minute
+)
+
+// keySet resolves token verification keys by key id, from static keys and a JWKS endpoint.
+type keySet struct {
+	url    string
+	client *http.Client
+	static map[string]crypto.PublicKey
+
+	mutex     sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newKeySet(url string, staticKeys map[string]string) (*keySet, error) {
+	ks := &keySet{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		static: make(map[string]crypto.PublicKey, len(staticKeys)),
+	}
+
+	for kid, data := range staticKeys {
+		key, err := parsePEMPublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("static key %s: %w", kid, err)
+		}
+
+		ks.static[kid] = key
+	}
+
+	if url == "" && len(ks.static) == 0 {
+		return nil, errors.New("jwt needs a jwks url or static keys")
+	}
+
+	return ks, nil
+}
+
+// key returns the public key for kid.
+// an empty kid is accepted only when a single static key is configured.
+func (ks *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := ks.static[kid]; ok {
+		return key, nil
+	}
+
+	if kid == "" && len(ks.static) == 1 && ks.url == "" {
+		for _, key := range ks.static {
+			return key, nil
+		}
+	}
+
+	if ks.url == "" {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	ks.mutex.RLock()
+	key, ok := ks.keys[kid]
+	age := time.Since(ks.fetchedAt)
+	ks.mutex.RUnlock()
+
+	if ok && age < jwksTTL {
+		return key, nil
+	}
+
+	// unknown key ids may mean the provider rotated its keys, but do not let tokens force a fetch per request
+	if !ok && age < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	err := ks.refresh(ctx)
+	if err != nil {
+		// keep serving the keys we have if the provider is briefly unavailable
+		if ok {
+			return key, nil
+		}
+
+		return nil, err
+	}
+
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	key, ok = ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	return key, nil
+}
+
+func (ks *keySet) refresh(ctx context.Context) error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	// another request may have refreshed while this one waited for the lock
+	if time.Since(ks.fetchedAt) < jwksMinRefresh {
+		return nil
+	}
+
+	// failed fetches are rate limited as well
+	ks.fetchedAt = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := ks.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching jwks: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching jwks: unexpected status %d", res.StatusCode)
+	}
+
+	var body struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return fmt.Errorf("decoding jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(body.Keys))
+
+	for _, jwk := range body.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			// skip keys this service can not use rather than failing the whole set
+			continue
+		}
+
+		keys[jwk.Kid] = key
+	}
+
+	ks.keys = keys
+
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(val string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+func parsePEMPublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+
+	"github.com/swagftw/stripe_pay_service/pkg/auth"
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+)
+
+// IsAPIKey tells api keys apart from tokens of the identity provider, bearer tokens with the prefix are left to APIKeyAuth.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, auth.KeyPrefix)
+}
+
+// only asymmetric algorithms are accepted, so a public key can never be used as an hmac secret.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// JWTVerifier validates bearer tokens issued by the identity provider and maps their roles to scopes.
+type JWTVerifier struct {
+	keys      *keySet
+	parser    *jwt.Parser
+	issuer    string
+	audience  string
+	leeway    time.Duration
+	roleClaim string
+	roles     map[string][]string
+}
+
+// NewJWTVerifier creates a verifier from the JWT config.
+func NewJWTVerifier(cfg *config.JWT) (*JWTVerifier, error) {
+	keys, err := newKeySet(cfg.JWKSURL, cfg.StaticKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, errors.New("jwt needs an issuer and an audience")
+	}
+
+	roleClaim := cfg.RoleClaim
+	if roleClaim == "" {
+		roleClaim = "roles"
+	}
+
+	return &JWTVerifier{
+		keys: keys,
+		// claims are validated by Verify to apply the leeway
+		parser:    jwt.NewParser(jwt.WithValidMethods(jwtMethods), jwt.WithoutClaimsValidation()),
+		issuer:    cfg.Issuer,
+		audience:  cfg.Audience,
+		leeway:    time.Duration(cfg.Leeway) * time.Second,
+		roleClaim: roleClaim,
+		roles:     cfg.Roles,
+	}, nil
+}
+
+// Verify validates the token signature, issuer, audience and expiry and returns the principal it represents.
+func (v *JWTVerifier) Verify(ctx context.Context, tokenString string) (*types.Principal, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := v.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		return v.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, unauthorizedToken(err)
+	}
+
+	now := time.Now()
+
+	switch {
+	case !claims.VerifyExpiresAt(now.Add(-v.leeway).Unix(), true):
+		return nil, unauthorizedToken(errors.New("token is expired or has no exp claim"))
+	case !claims.VerifyNotBefore(now.Add(v.leeway).Unix(), false):
+		return nil, unauthorizedToken(errors.New("token is not valid yet"))
+	case !claims.VerifyIssuer(v.issuer, true):
+		return nil, unauthorizedToken(errors.New("token has an unexpected issuer"))
+	case !claims.VerifyAudience(v.audience, true):
+		return nil, unauthorizedToken(errors.New("token has an unexpected audience"))
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, unauthorizedToken(errors.New("token has no sub claim"))
+	}
+
+	tokenID, _ := claims["jti"].(string)
+
+	// internal callers name the merchant they act for, tokens without one can not reach merchant data
+	merchantID, _ := claims["merchant_id"].(string)
+
+	return &types.Principal{
+		ClientID:   subject,
+		MerchantID: merchantID,
+		KeyID:      tokenID,
+		Scopes:     v.scopes(claims[v.roleClaim]),
+	}, nil
+}
+
+// scopes returns the scopes granted by the roles in the role claim, which may be a list or a space separated string.
+func (v *JWTVerifier) scopes(claim interface{}) []string {
+	var roles []string
+
+	switch val := claim.(type) {
+	case string:
+		roles = strings.Fields(val)
+	case []interface{}:
+		for _, role := range val {
+			if s, ok := role.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	scopes := make([]string, 0)
+
+	for _, role := range roles {
+		for _, scope := range v.roles[role] {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	return scopes
+}
+
+// JWTAuth authenticates requests carrying a JWT bearer token and puts the principal on the request context.
+// requests without a token, or with an api key, pass through untouched so APIKeyAuth can handle them.
+func JWTAuth(verifier *JWTVerifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Header.Get(echo.HeaderAuthorization) == "" {
+				return next(c)
+			}
+
+			token, err := bearerToken(c)
+			if err != nil || IsAPIKey(token) {
+				return next(c)
+			}
+
+			principal, err := verifier.Verify(c.Request().Context(), token)
+			if err != nil {
+				return err
+			}
+
+			setPrincipal(c, principal)
+
+			return next(c)
+		}
+	}
+}
+
+func unauthorizedToken(err error) error {
+	return fault.ErrUnauthorized.New("auth", "provide a valid bearer token in the Authorization header", err)
+}
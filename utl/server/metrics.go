@@ -0,0 +1,46 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/swagftw/stripe_pay_service/utl/metrics"
+)
+
+// MetricsPath is where the prometheus metrics are served.
+const MetricsPath = "/metrics"
+
+// Metrics records the count and duration of requests by method, route and status.
+// routes are labelled with their path template so ids in the url do not blow up the number of series.
+func Metrics() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+
+			// let the error handler write the response first, so the status it sends is recorded
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+
+			status := strconv.Itoa(c.Response().Status)
+
+			metrics.HTTPRequests.WithLabelValues(c.Request().Method, route, status).Inc()
+			metrics.HTTPDuration.WithLabelValues(c.Request().Method, route, status).Observe(time.Since(start).Seconds())
+
+			return nil
+		}
+	}
+}
+
+// MetricsHandler serves the prometheus metrics.
+func MetricsHandler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.Handler())
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/ratelimit"
+)
+
+// rate limit headers from the IETF RateLimit header fields draft.
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+)
+
+// RateLimiter limits requests per route group with token buckets.
+type RateLimiter struct {
+	cfg   *config.RateLimit
+	store ratelimit.Store
+}
+
+// NewRateLimiter creates a rate limiter applying the limits in cfg, the buckets are kept in store.
+func NewRateLimiter(cfg *config.RateLimit, store ratelimit.Store) *RateLimiter {
+	return &RateLimiter{cfg: cfg, store: store}
+}
+
+// Limit limits the requests of every caller to the routes of group.
+// callers are told apart by api key (or token subject) once authenticated and by ip otherwise,
+// so Limit should come after the authentication middleware.
+// groups without configured limits are not limited.
+func (r *RateLimiter) Limit(group string) echo.MiddlewareFunc {
+	return r.limit(group, rateLimitKey)
+}
+
+// LimitIP limits the requests of every ip to the routes of group, whether they authenticate or not.
+// it goes in front of the authentication middleware, so guessing credentials can not flood the api key lookups.
+func (r *RateLimiter) LimitIP(group string) echo.MiddlewareFunc {
+	return r.limit(group, func(c echo.Context) string {
+		return "ip:" + c.RealIP()
+	})
+}
+
+func (r *RateLimiter) limit(group string, key func(c echo.Context) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			res, err := r.Take(c.Request().Context(), group, key(c))
+			if res == nil {
+				return next(c)
+			}
+
+			header := c.Response().Header()
+			header.Set(HeaderRateLimitLimit, strconv.Itoa(res.Limit))
+			header.Set(HeaderRateLimitRemaining, strconv.Itoa(res.Remaining))
+			header.Set(HeaderRateLimitReset, ceilSeconds(res.Reset))
+
+			if err != nil {
+				header.Set(echo.HeaderRetryAfter, ceilSeconds(res.RetryAfter))
+
+				return err
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// Take takes a token of the caller identified by key from the bucket of group, for transports other than http.
+// the result is nil when the group is not limited or the store is unavailable, and the error is set once the
+// caller exceeded the limit.
+func (r *RateLimiter) Take(ctx context.Context, group, key string) (*ratelimit.Result, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	cfgRule, ok := r.cfg.Rule(group)
+	if !ok || cfgRule.Rate <= 0 || cfgRule.Burst <= 0 {
+		return nil, nil
+	}
+
+	rule := ratelimit.Rule{Rate: cfgRule.Rate, Burst: cfgRule.Burst}
+
+	res, err := r.store.Take(ctx, group+":"+key, rule)
+	if err != nil {
+		// an unavailable store must not take the api down with it
+		logger.Logger.Error(ctx, "error taking rate limit token, letting request through", err)
+
+		return nil, nil
+	}
+
+	if !res.Allowed {
+		retryAfter := ceilSeconds(res.RetryAfter)
+
+		return &res, fault.ErrRateLimited.New("ratelimit", "retry after "+retryAfter+" seconds", errors.New("rate limit of group "+group+" exceeded"))
+	}
+
+	return &res, nil
+}
+
+// rateLimitKey identifies the caller by api key, token subject or ip, in that order.
+// the ip is only used on routes limited without authentication.
+func rateLimitKey(c echo.Context) string {
+	if key, ok := CallerKey(c.Request().Context()); ok {
+		return key
+	}
+
+	return "ip:" + c.RealIP()
+}
+
+// CallerKey identifies the authenticated caller of ctx by api key or token subject, to limit its requests.
+func CallerKey(ctx context.Context) (string, bool) {
+	principal, ok := types.PrincipalFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	if principal.KeyID != "" {
+		return "key:" + principal.KeyID, true
+	}
+
+	return "client:" + principal.ClientID, true
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
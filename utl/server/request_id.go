@@ -0,0 +1,62 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/swagftw/stripe_pay_service/types"
+)
+
+// maxRequestIDLength bounds the request ids accepted from callers, longer ones are replaced.
+const maxRequestIDLength = 128
+
+// RequestID puts the request id on the request context and echoes it in the X-Request-ID response header.
+// the id sent by the caller is kept when it is sane, otherwise a new one is generated.
+func RequestID() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			requestID := RequestIDOrNew(c.Request().Header.Get(echo.HeaderXRequestID))
+
+			c.Response().Header().Set(echo.HeaderXRequestID, requestID)
+
+			c.SetRequest(c.Request().WithContext(types.WithRequestID(c.Request().Context(), requestID)))
+
+			return next(c)
+		}
+	}
+}
+
+// RequestIDOrNew returns the request id sent by a caller when it is sane, otherwise a new one.
+func RequestIDOrNew(requestID string) string {
+	if !validRequestID(requestID) {
+		return newRequestID()
+	}
+
+	return requestID
+}
+
+// validRequestID rejects empty and oversized ids and ids which could break log lines.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, r := range requestID {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+
+	// crypto/rand does not fail on supported platforms
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -2,9 +2,7 @@ package server
 
 import (
 	"context"
-	"fmt"
 	"net/http"
-	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -15,71 +13,120 @@ import (
 	"github.com/go-playground/validator/v10"
 
 	"github.com/swagftw/stripe_pay_service/utl/config"
-	"github.com/swagftw/stripe_pay_service/utl/constant"
 	"github.com/swagftw/stripe_pay_service/utl/logger"
 )
 
 // NewServer creates a new echo http server.
-func NewServer() *echo.Echo {
+func NewServer() (*echo.Echo, error) {
 	e := echo.New()
 
-	// using default middlewares
-	e.Use(middleware.Logger(), middleware.Recover(), middleware.CORS())
+	// using default middlewares, the request id comes first so everything after can log it
+	e.Use(RequestID(), middleware.Logger(), Tracing(), Metrics(), middleware.Recover())
+
+	// cross origin requests are only allowed from the configured origins
+	if origins := config.GetGlobalConfig().GetServerConfig().AllowedOrigins; len(origins) > 0 {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:  origins,
+			AllowHeaders:  []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, echo.HeaderXRequestID},
+			ExposeHeaders: []string{echo.HeaderXRequestID},
+		}))
+	}
+
+	// internal callers may authenticate with tokens from the identity provider instead of api keys
+	if jwtCfg := config.GetGlobalConfig().GetJWTConfig(); jwtCfg.Enabled {
+		verifier, err := NewJWTVerifier(jwtCfg)
+		if err != nil {
+			logger.Logger.Error(context.TODO(), "error creating jwt verifier", err)
+
+			return nil, err
+		}
+
+		e.Use(JWTAuth(verifier))
+	}
+
 	e.HTTPErrorHandler = ErrorHandler
 
-	e.Validator = &CustomValidator{V: validator.New()}
+	e.GET(MetricsPath, MetricsHandler())
+
+	e.Validator = &CustomValidator{V: NewValidator()}
 	e.Binder = &CustomBinder{b: &echo.DefaultBinder{}}
 
-	return e
+	return e, nil
 }
 
-// StartServer starts the echo http server.
-func StartServer(e *echo.Echo) {
-	cfg := config.GetGlobalConfig()
+// DefaultShutdownTimeout is how long in-flight requests are drained for when no shutdown timeout is configured.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// StartServer starts the echo http server and blocks until it fails or SIGINT or SIGTERM is received,
+// in which case in-flight requests are drained for at most the configured shutdown timeout.
+func StartServer(e *echo.Echo) error {
+	cfg := config.GetGlobalConfig().GetServerConfig()
 
 	// ping server to check if it is running
 	e.GET("/api/v1/ping", func(c echo.Context) error {
 		return c.String(http.StatusOK, "pong")
 	})
 
-	errChan := make(chan error)
+	srv := &http.Server{
+		Addr:         ":" + cfg.Port,
+		ReadTimeout:  time.Duration(cfg.Timeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.Timeout) * time.Second,
+		IdleTimeout:  time.Duration(cfg.Timeout) * time.Second,
+	}
 
-	// run server in background.
-	go func() {
-		srv := &http.Server{
-			Addr:         ":" + cfg.GetServerConfig().Port,
-			ReadTimeout:  time.Duration(cfg.GetServerConfig().Timeout) * time.Second,
-			WriteTimeout: time.Duration(cfg.GetServerConfig().Timeout) * time.Second,
-			IdleTimeout:  time.Duration(cfg.GetServerConfig().Timeout) * time.Second,
-		}
+	shutdownTimeout := time.Duration(cfg.ShutdownTimeout) * time.Second
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = DefaultShutdownTimeout
+	}
 
-		// listen and serve
-		err := e.StartServer(srv)
-		if err != nil {
-			errChan <- err
-		}
-	}()
+	// listen for SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	signalChan := make(chan os.Signal, 1)
+	return Serve(ctx, e, srv, shutdownTimeout)
+}
 
-	// listen for SIGINT or SIGTERM
+// Serve serves e on srv until ctx is done, then stops accepting connections and waits up to shutdownTimeout
+// for in-flight requests to finish. connections still open after that are closed.
+// the requests drained keep their own context, so a capture in flight is not cancelled by the shutdown.
+func Serve(ctx context.Context, e *echo.Echo, srv *http.Server, shutdownTimeout time.Duration) error {
+	errChan := make(chan error, 1)
+
+	// run server in background.
 	go func() {
-		signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-		sign := <-signalChan
-		logger.Logger.Info(context.Background(), fmt.Sprintf("Shutting down server: %v", sign))
+		errChan <- e.StartServer(srv)
 	}()
 
-	// waits for the error channel or signal channel to return and logs the error
 	select {
 	case err := <-errChan:
-		logger.Logger.Error(context.Background(), "shutting down server", err)
-	case sign := <-signalChan:
-		logger.Logger.Info(context.Background(), fmt.Sprintf("Shutting down server: %v", sign))
+		logger.Logger.Error(context.Background(), "server stopped", err)
+
+		return err
+	case <-ctx.Done():
+		logger.Logger.Info(context.Background(), "shutting down server, draining in-flight requests")
 	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// e.Shutdown only stops echo's own servers, srv is shut down directly
+	err := srv.Shutdown(drainCtx)
+	if err != nil {
+		logger.Logger.Error(context.Background(), "in-flight requests did not finish in time, closing their connections", err)
+
+		_ = srv.Close()
+
+		return err
+	}
+
+	logger.Logger.Info(context.Background(), "server stopped")
+
+	return nil
 }
 
+// ToGoContext returns the context of the request, carrying the request id, principal and trace set by the middlewares.
 func ToGoContext(e echo.Context) context.Context {
-	return context.WithValue(e.Request().Context(), constant.TxKey(constant.RequestIDKey), e.Request().Header.Get("X-Request-ID"))
+	return e.Request().Context()
 }
 
 // CustomValidator holds custom validator
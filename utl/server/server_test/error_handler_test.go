@@ -0,0 +1,196 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+// encryptionKeyOverride sets the encryption key the config requires, .env leaves it empty.
+const encryptionKeyOverride = "security.encryptionKey=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+func TestErrorHandler(t *testing.T) {
+	logger.InitLogger()
+
+	captured := fault.ErrAlreadyCaptured.New("stripeclient", "payment intent already captured", errors.New("charge ch_1 has already been captured"))
+	require.True(t, errors.Is(captured, fault.ErrAlreadyCaptured))
+
+	cases := []struct {
+		name        string
+		err         error
+		path        string
+		debug       bool
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+		wantErr     string
+		retryable   bool
+	}{
+		{
+			name:        "catalog error",
+			err:         captured,
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    "ERR_ALREADY_CAPTURED",
+			wantMessage: fault.ErrAlreadyCaptured.Message,
+		},
+		{
+			name:        "catalog error in debug mode",
+			err:         captured,
+			debug:       true,
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    "ERR_ALREADY_CAPTURED",
+			wantMessage: fault.ErrAlreadyCaptured.Message,
+			wantErr:     "charge ch_1 has already been captured",
+		},
+		{
+			name:        "wrapped catalog error",
+			err:         fmt.Errorf("capturing payment intent pi_1: %w", captured),
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    "ERR_ALREADY_CAPTURED",
+			wantMessage: fault.ErrAlreadyCaptured.Message,
+		},
+		{
+			name:        "retryable error",
+			err:         fault.ErrStripeUnavailable.New("stripeclient", "retry the request later", errors.New("dial tcp: i/o timeout")),
+			wantStatus:  http.StatusBadGateway,
+			wantCode:    "ERR_STRIPE_UNAVAILABLE",
+			wantMessage: fault.ErrStripeUnavailable.Message,
+			retryable:   true,
+		},
+		{
+			name:        "unknown error",
+			err:         fmt.Errorf("dial tcp 10.0.0.1:5432: connection refused"),
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    "ERR_INTERNAL_SERVER_ERROR",
+			wantMessage: fault.ErrInternal.Message,
+		},
+		{
+			name:        "unknown route",
+			path:        "/nowhere",
+			wantStatus:  http.StatusNotFound,
+			wantCode:    "ERR_NOT_FOUND",
+			wantMessage: fault.ErrNotFound.Message,
+		},
+		{
+			name:        "echo error",
+			err:         echo.NewHTTPError(http.StatusUnsupportedMediaType, "code=415, message=Unsupported Media Type"),
+			wantStatus:  http.StatusUnsupportedMediaType,
+			wantCode:    "ERR_UNSUPPORTED_MEDIA_TYPE",
+			wantMessage: fault.ErrUnsupportedMedia.Message,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := config.InitConfig("../../config/config.local.yaml", "", fmt.Sprintf("server.debug=%t", tt.debug), encryptionKeyOverride)
+			require.NoError(t, err)
+
+			e := echo.New()
+			e.HTTPErrorHandler = server.ErrorHandler
+			e.GET("/fail", func(c echo.Context) error {
+				return tt.err
+			})
+
+			path := tt.path
+			if path == "" {
+				path = "/fail"
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+			var body map[string]map[string]interface{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantCode, body["error"]["code"])
+			assert.Equal(t, tt.wantMessage, body["error"]["message"])
+			assert.Equal(t, tt.retryable, body["error"]["retryable"])
+
+			if tt.wantErr == "" {
+				assert.NotContains(t, body["error"], "error")
+			} else {
+				assert.Equal(t, tt.wantErr, body["error"]["error"])
+			}
+		})
+	}
+}
+
+func TestErrorHandlerProblem(t *testing.T) {
+	logger.InitLogger()
+
+	defer func() {
+		require.NoError(t, config.InitConfig("../../config/config.local.yaml", "", encryptionKeyOverride))
+	}()
+
+	cases := []struct {
+		name        string
+		format      string
+		accept      string
+		wantProblem bool
+	}{
+		{name: "envelope by default", format: config.ErrorFormatEnvelope, accept: "*/*"},
+		{name: "problem asked for", format: config.ErrorFormatEnvelope, accept: "application/problem+json", wantProblem: true},
+		{name: "problem by config", format: config.ErrorFormatProblem, accept: "*/*", wantProblem: true},
+		{name: "envelope asked for", format: config.ErrorFormatProblem, accept: "application/json, application/problem+json;q=0.5"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := config.InitConfig("../../config/config.local.yaml", "", "server.errorFormat="+tt.format, "server.debug=false", encryptionKeyOverride)
+			require.NoError(t, err)
+
+			e := echo.New()
+			e.HTTPErrorHandler = server.ErrorHandler
+			e.Use(server.RequestID())
+			e.GET("/fail", func(c echo.Context) error {
+				return fault.ErrCardDeclined.New("stripeclient", "ask the customer for another payment method", errors.New("card declined"))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/fail", nil)
+			req.Header.Set(echo.HeaderAccept, tt.accept)
+			req.Header.Set(echo.HeaderXRequestID, "req-1")
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusPaymentRequired, rec.Code)
+
+			if !tt.wantProblem {
+				assert.Equal(t, echo.MIMEApplicationJSONCharsetUTF8, rec.Header().Get(echo.HeaderContentType))
+
+				var body map[string]fault.ErrResponse
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				assert.Equal(t, "ERR_CARD_DECLINED", body["error"].Code)
+
+				return
+			}
+
+			assert.Equal(t, fault.MIMEProblemJSON, rec.Header().Get(echo.HeaderContentType))
+
+			var problem fault.Problem
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+			assert.Equal(t, fault.Problem{
+				Type:     fault.ProblemType("ERR_CARD_DECLINED"),
+				Title:    fault.ErrCardDeclined.Message,
+				Status:   http.StatusPaymentRequired,
+				Detail:   "ask the customer for another payment method",
+				Instance: "req-1",
+				Code:     "ERR_CARD_DECLINED",
+				Service:  "stripeclient",
+			}, problem)
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+var roles = map[string][]string{
+	"payments.creator":  {types.ScopePaymentsRead, types.ScopePaymentsWrite},
+	"payments.capturer": {types.ScopePaymentsRead, types.ScopePaymentsCapture},
+	"payments.refunder": {types.ScopePaymentsRead, types.ScopeRefundsWrite},
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// newJWKSServer serves the public part of key under kid.
+func newJWKSServer(t *testing.T, kid string, key *rsa.PrivateKey) *httptest.Server {
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": kid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func validClaims(roles ...string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "billing-service",
+		"iss":   "https://idp.internal",
+		"aud":   "stripe-pay-service",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"roles": roles,
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	jwksKey := newRSAKey(t)
+	jwksServer := newJWKSServer(t, "jwks-key", jwksKey)
+
+	staticKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&staticKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := server.NewJWTVerifier(&config.JWT{
+		Enabled:    true,
+		JWKSURL:    jwksServer.URL,
+		StaticKeys: map[string]string{"static-key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))},
+		Issuer:     "https://idp.internal",
+		Audience:   "stripe-pay-service",
+		Leeway:     5,
+		Roles:      roles,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := validClaims("payments.creator")
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+
+	wrongAudience := validClaims("payments.creator")
+	wrongAudience["aud"] = "another-service"
+
+	wrongIssuer := validClaims("payments.creator")
+	wrongIssuer["iss"] = "https://evil.example"
+
+	noExpiry := validClaims("payments.creator")
+	delete(noExpiry, "exp")
+
+	cases := []struct {
+		name       string
+		token      string
+		wantErr    bool
+		wantScopes []string
+	}{
+		{
+			name:       "jwks key",
+			token:      sign(t, jwt.SigningMethodRS256, "jwks-key", jwksKey, validClaims("payments.creator")),
+			wantScopes: []string{types.ScopePaymentsRead, types.ScopePaymentsWrite},
+		},
+		{
+			name:       "static key",
+			token:      sign(t, jwt.SigningMethodES256, "static-key", staticKey, validClaims("payments.capturer", "payments.refunder")),
+			wantScopes: []string{types.ScopePaymentsRead, types.ScopePaymentsCapture, types.ScopeRefundsWrite},
+		},
+		{
+			name:       "unknown role",
+			token:      sign(t, jwt.SigningMethodRS256, "jwks-key", jwksKey, validClaims("admin")),
+			wantScopes: []string{},
+		},
+		{
+			name:    "unknown key",
+			token:   sign(t, jwt.SigningMethodRS256, "jwks-key", newRSAKey(t), validClaims("payments.creator")),
+			wantErr: true,
+		},
+		{
+			name:    "hmac token",
+			token:   sign(t, jwt.SigningMethodHS256, "jwks-key", []byte("secret"), validClaims("payments.creator")),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			token:   sign(t, jwt.SigningMethodRS256, "jwks-key", jwksKey, expired),
+			wantErr: true,
+		},
+		{
+			name:    "no expiry",
+			token:   sign(t, jwt.SigningMethodRS256, "jwks-key", jwksKey, noExpiry),
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			token:   sign(t, jwt.SigningMethodRS256, "jwks-key", jwksKey, wrongAudience),
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			token:   sign(t, jwt.SigningMethodRS256, "jwks-key", jwksKey, wrongIssuer),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := verifier.Verify(context.TODO(), tt.token)
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if !tt.wantErr {
+				assert.Equal(t, "billing-service", principal.ClientID)
+				assert.Equal(t, tt.wantScopes, principal.Scopes)
+			}
+		})
+	}
+}
+
+func TestJWTAuthMiddleware(t *testing.T) {
+	logger.InitLogger()
+
+	key := newRSAKey(t)
+	jwksServer := newJWKSServer(t, "jwks-key", key)
+
+	verifier, err := server.NewJWTVerifier(&config.JWT{
+		JWKSURL:  jwksServer.URL,
+		Issuer:   "https://idp.internal",
+		Audience: "stripe-pay-service",
+		Roles:    roles,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.HTTPErrorHandler = server.ErrorHandler
+	e.Use(server.JWTAuth(verifier))
+
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+
+	e.POST("/create_intent", ok, server.RequireScope(types.ScopePaymentsWrite))
+	e.POST("/capture_intent/:id", ok, server.RequireScope(types.ScopePaymentsCapture))
+	e.POST("/create_refund/:id", ok, server.RequireScope(types.ScopeRefundsWrite))
+
+	creator := sign(t, jwt.SigningMethodRS256, "jwks-key", key, validClaims("payments.creator"))
+	capturer := sign(t, jwt.SigningMethodRS256, "jwks-key", key, validClaims("payments.capturer"))
+
+	cases := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{name: "creator creates intent", path: "/create_intent", token: creator, wantStatus: http.StatusOK},
+		{name: "creator can not capture", path: "/capture_intent/pi_1", token: creator, wantStatus: http.StatusForbidden},
+		{name: "creator can not refund", path: "/create_refund/pi_1", token: creator, wantStatus: http.StatusForbidden},
+		{name: "capturer captures intent", path: "/capture_intent/pi_1", token: capturer, wantStatus: http.StatusOK},
+		{name: "capturer can not create intent", path: "/create_intent", token: capturer, wantStatus: http.StatusForbidden},
+		{name: "invalid token", path: "/create_intent", token: "not.a.jwt", wantStatus: http.StatusUnauthorized},
+		{name: "no token", path: "/create_intent", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tt.token)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/metrics"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+func TestMetrics(t *testing.T) {
+	logger.InitLogger()
+
+	e := echo.New()
+	e.HTTPErrorHandler = server.ErrorHandler
+	e.Use(server.Metrics())
+	e.GET(server.MetricsPath, server.MetricsHandler())
+
+	e.POST("/metrics_test/capture_intent/:id", func(c echo.Context) error {
+		if c.Param("id") == "pi_conflict" {
+			return fault.New(http.StatusConflict, "payments", "conflict", "try again", "ERR_CONFLICT", errors.New("conflict"))
+		}
+
+		return c.NoContent(http.StatusOK)
+	})
+
+	route := "/metrics_test/capture_intent/:id"
+
+	cases := []struct {
+		name   string
+		path   string
+		status string
+	}{
+		{name: "success is labelled with the route template", path: "/metrics_test/capture_intent/pi_1", status: "200"},
+		{name: "error is labelled with the status sent by the error handler", path: "/metrics_test/capture_intent/pi_conflict", status: "409"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(http.MethodPost, route, tt.status))
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, nil))
+
+			assert.Equal(t, tt.status, strconv.Itoa(rec.Code))
+			assert.Equal(t, before+1, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(http.MethodPost, route, tt.status)))
+		})
+	}
+
+	t.Run("metrics endpoint", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, server.MetricsPath, nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `stripe_pay_http_requests_total{method="POST",route="/metrics_test/capture_intent/:id",status="409"}`)
+	})
+}
@@ -0,0 +1,149 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/ratelimit"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+// keyAuth resolves api keys to principals with the key as key id.
+type keyAuth struct{}
+
+func (keyAuth) Authenticate(_ context.Context, apiKey string) (*types.Principal, error) {
+	return &types.Principal{ClientID: "client_1", MerchantID: "client_1", KeyID: apiKey}, nil
+}
+
+func TestRateLimiter(t *testing.T) {
+	logger.InitLogger()
+
+	limiter := server.NewRateLimiter(&config.RateLimit{
+		Groups: map[string]config.RateLimitRule{
+			// refills too slowly to matter during the test
+			"refunds": {Rate: 0.001, Burst: 2},
+		},
+	}, ratelimit.NewMemoryStore())
+
+	e := echo.New()
+	e.HTTPErrorHandler = server.ErrorHandler
+
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+
+	authed := e.Group("", server.APIKeyAuth(keyAuth{}))
+	authed.POST("/create_refund/:id", ok, limiter.Limit("refunds"))
+	authed.POST("/create_intent", ok, limiter.Limit("payments"))
+	e.GET("/public", ok, limiter.Limit("refunds"))
+
+	cases := []struct {
+		name          string
+		method        string
+		path          string
+		apiKey        string
+		ip            string
+		wantStatus    int
+		wantRemaining string
+	}{
+		{name: "first request", method: http.MethodPost, path: "/create_refund/pi_1", apiKey: "key_a", wantStatus: http.StatusOK, wantRemaining: "1"},
+		{name: "second request", method: http.MethodPost, path: "/create_refund/pi_1", apiKey: "key_a", wantStatus: http.StatusOK, wantRemaining: "0"},
+		{name: "bucket empty", method: http.MethodPost, path: "/create_refund/pi_1", apiKey: "key_a", wantStatus: http.StatusTooManyRequests, wantRemaining: "0"},
+		{name: "other api key has own bucket", method: http.MethodPost, path: "/create_refund/pi_1", apiKey: "key_b", wantStatus: http.StatusOK, wantRemaining: "1"},
+		{name: "unconfigured group is not limited", method: http.MethodPost, path: "/create_intent", apiKey: "key_a", wantStatus: http.StatusOK},
+		{name: "first request by ip", method: http.MethodGet, path: "/public", ip: "10.0.0.1", wantStatus: http.StatusOK, wantRemaining: "1"},
+		{name: "other ip has own bucket", method: http.MethodGet, path: "/public", ip: "10.0.0.2", wantStatus: http.StatusOK, wantRemaining: "1"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tt.apiKey)
+			}
+
+			if tt.ip != "" {
+				req.Header.Set(echo.HeaderXRealIP, tt.ip)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantRemaining, rec.Header().Get(server.HeaderRateLimitRemaining))
+
+			if tt.wantRemaining != "" {
+				assert.Equal(t, "2", rec.Header().Get(server.HeaderRateLimitLimit))
+			}
+
+			if tt.wantStatus == http.StatusTooManyRequests {
+				assert.NotEmpty(t, rec.Header().Get(echo.HeaderRetryAfter))
+
+				var body map[string]map[string]interface{}
+
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				assert.Equal(t, float64(http.StatusTooManyRequests), body["error"]["status"])
+			}
+		})
+	}
+}
+
+// countingAuth refuses every api key and counts the lookups.
+type countingAuth struct {
+	lookups int
+}
+
+func (a *countingAuth) Authenticate(_ context.Context, _ string) (*types.Principal, error) {
+	a.lookups++
+
+	return nil, fault.ErrUnauthorized.New("auth", "provide a valid api key", errors.New("unknown api key"))
+}
+
+func TestRateLimiterBeforeAuth(t *testing.T) {
+	logger.InitLogger()
+
+	limiter := server.NewRateLimiter(&config.RateLimit{
+		Groups: map[string]config.RateLimitRule{
+			"auth": {Rate: 0.001, Burst: 2},
+		},
+	}, ratelimit.NewMemoryStore())
+
+	authService := &countingAuth{}
+
+	e := echo.New()
+	e.HTTPErrorHandler = server.ErrorHandler
+
+	group := e.Group("", limiter.LimitIP("auth"), server.APIKeyAuth(authService))
+	group.POST("/create_intent", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	send := func(ip, apiKey string) int {
+		req := httptest.NewRequest(http.MethodPost, "/create_intent", nil)
+		req.Header.Set(echo.HeaderXRealIP, ip)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+apiKey)
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send("10.0.0.1", "psk_guess1"))
+	assert.Equal(t, http.StatusUnauthorized, send("10.0.0.1", "psk_guess2"))
+	assert.Equal(t, http.StatusTooManyRequests, send("10.0.0.1", "psk_guess3"))
+	assert.Equal(t, 2, authService.lookups, "limited requests do not reach the api key lookup")
+
+	assert.Equal(t, http.StatusUnauthorized, send("10.0.0.2", "psk_guess4"), "other ips have their own bucket")
+}
@@ -0,0 +1,60 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+func TestRequestID(t *testing.T) {
+	e := echo.New()
+	e.Use(server.RequestID())
+
+	e.GET("/request_id", func(c echo.Context) error {
+		requestID, _ := types.RequestIDFromContext(server.ToGoContext(c))
+
+		return c.String(http.StatusOK, requestID)
+	})
+
+	cases := []struct {
+		name     string
+		sent     string
+		wantKept bool
+	}{
+		{name: "generated when missing"},
+		{name: "kept when sent", sent: "req-7f3a9c", wantKept: true},
+		{name: "replaced when it could break log lines", sent: "req\nforged=1"},
+		{name: "replaced when too long", sent: strings.Repeat("a", 129)},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/request_id", nil)
+			if tt.sent != "" {
+				req.Header.Set(echo.HeaderXRequestID, tt.sent)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			requestID := rec.Header().Get(echo.HeaderXRequestID)
+
+			assert.NotEmpty(t, requestID)
+			assert.Equal(t, requestID, rec.Body.String())
+
+			if tt.wantKept {
+				assert.Equal(t, tt.sent, requestID)
+			} else {
+				assert.NotEqual(t, tt.sent, requestID)
+				assert.Len(t, requestID, 32)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	logger.InitLogger()
+
+	cases := []struct {
+		name            string
+		handlerTime     time.Duration
+		shutdownTimeout time.Duration
+		wantErr         error
+		wantStatus      int
+	}{
+		{name: "request finishes within the deadline", handlerTime: 100 * time.Millisecond, shutdownTimeout: time.Second, wantStatus: http.StatusOK},
+		{name: "request outlives the deadline", handlerTime: time.Second, shutdownTimeout: 50 * time.Millisecond, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			started := make(chan struct{})
+
+			e := echo.New()
+			e.HideBanner = true
+			e.HidePort = true
+			e.Listener = listener
+			e.POST("/capture", func(c echo.Context) error {
+				close(started)
+
+				select {
+				case <-time.After(tt.handlerTime):
+					return c.NoContent(http.StatusOK)
+				case <-c.Request().Context().Done():
+					return c.Request().Context().Err()
+				}
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			served := make(chan error, 1)
+			go func() {
+				served <- server.Serve(ctx, e, &http.Server{}, tt.shutdownTimeout)
+			}()
+
+			url := "http://" + listener.Addr().String() + "/capture"
+
+			responded := make(chan int, 1)
+			go func() {
+				res, err := http.Post(url, echo.MIMEApplicationJSON, nil)
+				if err != nil {
+					responded <- 0
+
+					return
+				}
+
+				_ = res.Body.Close()
+				responded <- res.StatusCode
+			}()
+
+			<-started
+			cancel()
+
+			assert.ErrorIs(t, <-served, tt.wantErr)
+			assert.Equal(t, tt.wantStatus, <-responded)
+
+			// no connection is accepted after the shutdown
+			_, err = http.Post(url, echo.MIMEApplicationJSON, nil)
+			assert.Error(t, err)
+		})
+	}
+}
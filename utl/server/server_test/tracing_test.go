@@ -0,0 +1,96 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+	"github.com/swagftw/stripe_pay_service/utl/tracing"
+)
+
+func TestTracing(t *testing.T) {
+	logger.InitLogger()
+
+	// the none exporter still installs the W3C propagator
+	_, err := tracing.Init(context.Background(), &config.Tracing{Exporter: tracing.ExporterNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	e := echo.New()
+	e.HTTPErrorHandler = server.ErrorHandler
+	e.Use(server.Tracing())
+
+	e.POST("/tracing_test/capture_intent/:id", func(c echo.Context) error {
+		// spans started from the request context are children of the server span
+		_, span := tracing.Start(c.Request().Context(), "payments.CapturePaymentIntent")
+		span.End()
+
+		if c.Param("id") == "pi_broken" {
+			return errors.New("broken")
+		}
+
+		return c.NoContent(http.StatusOK)
+	})
+
+	cases := []struct {
+		name        string
+		path        string
+		traceparent string
+		wantStatus  int
+		wantCode    codes.Code
+	}{
+		{name: "continues the trace of the caller", path: "/tracing_test/capture_intent/pi_1", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantStatus: http.StatusOK, wantCode: codes.Unset},
+		{name: "starts a new trace", path: "/tracing_test/capture_intent/pi_1", wantStatus: http.StatusOK, wantCode: codes.Unset},
+		{name: "marks server errors", path: "/tracing_test/capture_intent/pi_broken", wantStatus: http.StatusInternalServerError, wantCode: codes.Error},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(recorder.Ended())
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			spans := recorder.Ended()[before:]
+			if !assert.Len(t, spans, 2) {
+				return
+			}
+
+			child, serverSpan := spans[0], spans[1]
+
+			assert.Equal(t, "POST /tracing_test/capture_intent/:id", serverSpan.Name())
+			assert.Equal(t, tt.wantCode, serverSpan.Status().Code)
+			assert.Contains(t, serverSpan.Attributes(), semconv.HTTPStatusCode(tt.wantStatus))
+			assert.Equal(t, serverSpan.SpanContext().SpanID(), child.Parent().SpanID())
+
+			if tt.traceparent != "" {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", serverSpan.SpanContext().TraceID().String())
+				assert.Equal(t, "00f067aa0ba902b7", serverSpan.Parent().SpanID().String())
+			}
+		})
+	}
+}
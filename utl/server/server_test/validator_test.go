@@ -0,0 +1,108 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/server"
+)
+
+func TestValidation(t *testing.T) {
+	logger.InitLogger()
+
+	err := config.InitConfig("../../config/config.local.yaml", "", "server.debug=false", encryptionKeyOverride)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name       string
+		body       string
+		wantFields []fault.FieldError
+	}{
+		{
+			name: "valid",
+			body: `{"amount":5000,"currency":"USD","email":"jane@example.com","phone":"+919876543210"}`,
+		},
+		{
+			name: "missing fields",
+			body: `{}`,
+			wantFields: []fault.FieldError{
+				{Field: "amount", Rule: "required", Message: "is required"},
+				{Field: "email", Rule: "required", Message: "is required"},
+			},
+		},
+		{
+			name: "invalid fields",
+			body: `{"amount":10,"currency":"rupees","email":"jane","phone":"9876543210"}`,
+			wantFields: []fault.FieldError{
+				{Field: "amount", Rule: "amount", Message: "must be between 50 and 99999999 in the smallest currency unit"},
+				{Field: "currency", Rule: "currency", Message: "must be a three letter ISO 4217 currency code"},
+				{Field: "email", Rule: "email", Message: "must be an email address"},
+				{Field: "phone", Rule: "e164", Message: "must be a phone number in E.164 format, such as +919876543210"},
+			},
+		},
+		{
+			name: "amount too large for stripe",
+			body: `{"amount":100000000,"currency":"inr","email":"jane@example.com"}`,
+			wantFields: []fault.FieldError{
+				{Field: "amount", Rule: "amount", Message: "must be between 50 and 99999999 in the smallest currency unit"},
+			},
+		},
+		{
+			name: "unknown currency",
+			body: `{"amount":5000,"currency":"xyz","email":"jane@example.com"}`,
+			wantFields: []fault.FieldError{
+				{Field: "currency", Rule: "currency", Message: "must be a three letter ISO 4217 currency code"},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.HTTPErrorHandler = server.ErrorHandler
+			e.Validator = &server.CustomValidator{V: server.NewValidator()}
+			e.POST("/intents", func(c echo.Context) error {
+				req := new(types.CreateIntentReq)
+				if err := c.Bind(req); err != nil {
+					return err
+				}
+
+				if err := c.Validate(req); err != nil {
+					return err
+				}
+
+				return c.NoContent(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/intents", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if tt.wantFields == nil {
+				assert.Equal(t, http.StatusNoContent, rec.Code)
+
+				return
+			}
+
+			var body map[string]fault.ErrResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Equal(t, "ERR_VALIDATION", body["error"].Code)
+			assert.Equal(t, tt.wantFields, body["error"].Fields)
+		})
+	}
+}
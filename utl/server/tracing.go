@@ -0,0 +1,64 @@
+package server
+
+import (
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/tracing"
+)
+
+// Tracing starts a server span for every request, continuing the trace of the caller when it sent a W3C traceparent header.
+// the span is put on the request context, so spans started further down become its children.
+func Tracing() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+
+			ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+			ctx, span := tracing.Start(ctx, req.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPMethod(req.Method),
+					semconv.HTTPRoute(route),
+					semconv.HTTPTarget(req.URL.Path),
+					attribute.String("net.sock.peer.addr", c.RealIP()),
+				),
+			)
+			defer span.End()
+
+			if requestID, ok := types.RequestIDFromContext(ctx); ok {
+				span.SetAttributes(attribute.String("request.id", requestID))
+			}
+
+			c.SetRequest(req.WithContext(ctx))
+
+			// let the error handler write the response first, so the status it sends is recorded
+			err := next(c)
+			if err != nil {
+				span.RecordError(err)
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			span.SetAttributes(semconv.HTTPStatusCode(status))
+
+			if status >= 500 {
+				span.SetStatus(codes.Error, "")
+			}
+
+			return nil
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+)
+
+// bounds of the amounts stripe charges, in the smallest currency unit.
+const (
+	MinAmount = 50
+	MaxAmount = 99999999
+)
+
+// NewValidator returns the validator of request bodies, with the custom rules registered and fields named
+// after their json keys.
+func NewValidator() *validator.Validate {
+	v := validator.New()
+
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || name == "" {
+			return field.Name
+		}
+
+		return name
+	})
+
+	// stripe takes currencies in lower case, iso 4217 lists them in upper case
+	_ = v.RegisterValidation("currency", func(fl validator.FieldLevel) bool {
+		return v.Var(strings.ToUpper(fl.Field().String()), "iso4217") == nil
+	})
+
+	_ = v.RegisterValidation("amount", func(fl validator.FieldLevel) bool {
+		amount := fl.Field().Int()
+
+		return amount >= MinAmount && amount <= MaxAmount
+	})
+
+	return v
+}
+
+// ValidationError returns the catalog error of a failed validation, listing the invalid fields.
+func ValidationError(errs validator.ValidationErrors) error {
+	err := fault.ErrValidation.New("", "fix the invalid fields", errs).(*fault.HTTPError)
+	err.Fields = fieldErrors(errs)
+
+	return err
+}
+
+// ruleMessages explain the rules to users, rules without one are answered with a generic message.
+var ruleMessages = map[string]string{
+	"required": "is required",
+	"email":    "must be an email address",
+	"e164":     "must be a phone number in E.164 format, such as +919876543210",
+	"currency": "must be a three letter ISO 4217 currency code",
+	"amount":   fmt.Sprintf("must be between %d and %d in the smallest currency unit", MinAmount, MaxAmount),
+}
+
+// fieldErrors lists the invalid fields of a request, with the rule each one fails.
+func fieldErrors(errs validator.ValidationErrors) []fault.FieldError {
+	fields := make([]fault.FieldError, 0, len(errs))
+
+	for _, fe := range errs {
+		message, ok := ruleMessages[fe.Tag()]
+		if !ok {
+			message = "is invalid"
+		}
+
+		fields = append(fields, fault.FieldError{
+			Field:   fieldPath(fe.Namespace()),
+			Rule:    fe.Tag(),
+			Message: message,
+		})
+	}
+
+	return fields
+}
+
+// fieldPath drops the struct name leading the namespace of a field.
+func fieldPath(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+
+	return namespace
+}
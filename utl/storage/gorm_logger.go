@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+)
+
+// slowQueryThreshold is the duration above which queries are logged as slow.
+const slowQueryThreshold = 200 * time.Millisecond
+
+// zapGormLogger writes gorm logs through the service logger, so they share its level, encoding and request fields.
+// failed queries are logged as errors, slow queries as warnings and every query at debug level.
+type zapGormLogger struct {
+	level gormLogger.LogLevel
+}
+
+func newGormLogger() gormLogger.Interface {
+	return zapGormLogger{level: gormLogger.Info}
+}
+
+// LogMode returns a logger logging at most at level.
+func (l zapGormLogger) LogMode(level gormLogger.LogLevel) gormLogger.Interface {
+	return zapGormLogger{level: level}
+}
+
+// Info logs an info message.
+func (l zapGormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormLogger.Info {
+		logger.Logger.Info(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+// Warn logs a warning message.
+func (l zapGormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormLogger.Warn {
+		logger.Logger.Warn(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+// Error logs an error message.
+func (l zapGormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormLogger.Error {
+		logger.Logger.Error(ctx, "database error", errors.New(fmt.Sprintf(msg, args...)))
+	}
+}
+
+// Trace logs a finished query.
+// record not found is left to the repositories, which turn it into a fault for the caller.
+func (l zapGormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.level <= gormLogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= gormLogger.Error:
+		sql, rows := fc()
+		logger.Logger.Error(ctx, "database query failed", err, queryFields(sql, rows, elapsed))
+	case elapsed > slowQueryThreshold && l.level >= gormLogger.Warn:
+		sql, rows := fc()
+		logger.Logger.Warn(ctx, "slow database query", queryFields(sql, rows, elapsed))
+	case l.level >= gormLogger.Info && logger.Logger.DebugEnabled():
+		sql, rows := fc()
+		logger.Logger.Debug(ctx, "database query", queryFields(sql, rows, elapsed))
+	}
+}
+
+func queryFields(sql string, rows int64, elapsed time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"sql":        sql,
+		"rows":       rows,
+		"elapsed_ms": float64(elapsed.Microseconds()) / 1000,
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/utl/metrics"
+	"github.com/swagftw/stripe_pay_service/utl/tracing"
+)
+
+// keys of the values kept on the gorm statement while a query runs.
+const (
+	queryStartKey  = "instrument:query_start"
+	querySpanKey   = "instrument:query_span"
+	queryParentKey = "instrument:query_parent"
+)
+
+// instrumentPlugin records the duration of every gorm query and traces it as a child of the span on the query context.
+type instrumentPlugin struct{}
+
+// Name returns the name of the gorm plugin.
+func (instrumentPlugin) Name() string {
+	return "instrument"
+}
+
+// Initialize registers the callbacks around every gorm operation.
+func (instrumentPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+
+	registrations := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{operation: "create", before: callbacks.Create().Before("gorm:create").Register, after: callbacks.Create().After("gorm:create").Register},
+		{operation: "query", before: callbacks.Query().Before("gorm:query").Register, after: callbacks.Query().After("gorm:query").Register},
+		{operation: "update", before: callbacks.Update().Before("gorm:update").Register, after: callbacks.Update().After("gorm:update").Register},
+		{operation: "delete", before: callbacks.Delete().Before("gorm:delete").Register, after: callbacks.Delete().After("gorm:delete").Register},
+		{operation: "row", before: callbacks.Row().Before("gorm:row").Register, after: callbacks.Row().After("gorm:row").Register},
+		{operation: "raw", before: callbacks.Raw().Before("gorm:raw").Register, after: callbacks.Raw().After("gorm:raw").Register},
+	}
+
+	for _, reg := range registrations {
+		err := reg.before("instrument:before_"+reg.operation, startQuery(reg.operation))
+		if err != nil {
+			return err
+		}
+
+		err = reg.after("instrument:after_"+reg.operation, observeQuery(reg.operation))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startQuery(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		db.InstanceSet(queryStartKey, time.Now())
+
+		// the span goes on the statement context until the query is done, so the driver sees it
+		db.InstanceSet(queryParentKey, db.Statement.Context)
+
+		ctx, span := tracing.Start(db.Statement.Context, "db."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(operation)),
+		)
+		db.Statement.Context = ctx
+		db.InstanceSet(querySpanKey, span)
+	}
+}
+
+func observeQuery(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+
+		if val, ok := db.InstanceGet(queryStartKey); ok {
+			if start, ok := val.(time.Time); ok {
+				metrics.DBQueryDuration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+			}
+		}
+
+		if val, ok := db.InstanceGet(querySpanKey); ok {
+			if span, ok := val.(trace.Span); ok {
+				// the statement holds placeholders only, never the values
+				span.SetAttributes(semconv.DBSQLTable(table), semconv.DBStatement(db.Statement.SQL.String()))
+
+				err := db.Error
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					err = nil
+				}
+
+				tracing.End(span, err)
+			}
+		}
+
+		// statements are reused by chained calls, the next query must not become a child of this one
+		if val, ok := db.InstanceGet(queryParentKey); ok {
+			if parent, ok := val.(context.Context); ok {
+				db.Statement.Context = parent
+			}
+		}
+	}
+}
@@ -3,61 +3,247 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"fmt"
-	"log"
 	"net"
-	"os"
+	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 
 	"github.com/swagftw/stripe_pay_service/utl/config"
 	"github.com/swagftw/stripe_pay_service/utl/constant"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/metrics"
 )
 
-// NewPostgresDB creates a new postgres db connection.
+// connect retry backoff, doubling from connectBackoff up to maxConnectBackoff.
+const (
+	connectBackoff    = time.Second
+	maxConnectBackoff = 30 * time.Second
+)
+
+// NewPostgresDB creates a new postgres db connection pool from the database config.
+// connecting is retried while postgres is still starting. reads are routed to the configured replicas
+// by GetReadDBFromContext.
 func NewPostgresDB() (*gorm.DB, error) {
-	cfg := config.GetGlobalConfig()
+	dbCfg := config.GetGlobalConfig().GetDBConfig()
 
-	dbCfg := cfg.GetDBConfig()
-	user := dbCfg.User
-	password := dbCfg.Password
-	host := net.JoinHostPort(dbCfg.Host, dbCfg.Port)
-	dbname := dbCfg.Database
+	// rotated passwords are picked up by the connections made after the rotation
+	gormDB, err := open(DSN(dbCfg), dbCfg, "primary", config.GetGlobalConfig().GetDBPassword)
+	if err != nil {
+		return nil, err
+	}
 
-	sqlDB, err := sql.Open("pgx", fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable", user, password, host, dbname))
+	sqlDB, err := gormDB.DB()
 	if err != nil {
 		return nil, err
 	}
 
-	dbLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
-		logger.Config{
-			SlowThreshold:             time.Millisecond * 200, // Slow SQL threshold
-			LogLevel:                  logger.Error,           // Log level
-			IgnoreRecordNotFoundError: false,                  // Ignore ErrRecordNotFound error for logger
-			Colorful:                  true,                   // Disable color
-		},
-	)
+	err = connect(context.Background(), sqlDB, dbCfg.ConnectRetries, time.Duration(dbCfg.Timeout)*time.Second)
+	if err != nil {
+		_ = sqlDB.Close()
+
+		return nil, err
+	}
+
+	if len(dbCfg.Replicas) > 0 {
+		dbs, err := openReplicas(dbCfg)
+		if err != nil {
+			_ = sqlDB.Close()
+
+			return nil, err
+		}
+
+		err = UseReplicas(gormDB, dbs, time.Duration(dbCfg.MaxReplicaLag)*time.Second, ReplicationLag)
+		if err != nil {
+			closePools(dbs)
+			_ = sqlDB.Close()
+
+			return nil, err
+		}
+	}
+
+	return gormDB, nil
+}
+
+// open opens a pool to dsn sized by the database config, its statistics are exported labelled with name.
+// each connection is made with the password returned by password when it is set, rather than the one in dsn.
+// no connection is made until the pool is used.
+func open(dsn string, dbCfg *config.DB, name string, password func() string) (*gorm.DB, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []stdlib.OptionOpenDB
+
+	if password != nil {
+		opts = append(opts, stdlib.OptionBeforeConnect(func(_ context.Context, connConfig *pgx.ConnConfig) error {
+			connConfig.Password = password()
+
+			return nil
+		}))
+	}
+
+	sqlDB := stdlib.OpenDB(*connConfig, opts...)
+
+	sqlDB.SetMaxOpenConns(dbCfg.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(dbCfg.ConnMaxLifetime) * time.Second)
+
+	if dbCfg.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(dbCfg.MaxIdleConns)
+	}
 
 	gormDB, err := gorm.Open(postgres.New(postgres.Config{
 		Conn: sqlDB,
 	}), &gorm.Config{
-		Logger: dbLogger,
+		Logger:               newGormLogger(),
+		DisableAutomaticPing: true,
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	// record query durations and trace queries
+	err = gormDB.Use(instrumentPlugin{})
+	if err != nil {
+		return nil, err
+	}
+
+	// expose the pool statistics
+	err = metrics.RegisterDBStats(sqlDB, name)
+	if err != nil {
+		return nil, err
+	}
+
 	return gormDB, nil
 }
 
+// DSN returns the connection string of the database config.
+func DSN(dbCfg *config.DB) string {
+	query := url.Values{}
+
+	sslMode := dbCfg.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	query.Set("sslmode", sslMode)
+
+	params := map[string]string{
+		"sslrootcert":      dbCfg.SSLRootCert,
+		"sslcert":          dbCfg.SSLCert,
+		"sslkey":           dbCfg.SSLKey,
+		"application_name": dbCfg.ApplicationName,
+	}
+
+	for key, value := range params {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	if dbCfg.Timeout > 0 {
+		query.Set("connect_timeout", strconv.Itoa(dbCfg.Timeout))
+	}
+
+	// unknown parameters are sent to postgres as session settings
+	if dbCfg.StatementTimeout > 0 {
+		query.Set("statement_timeout", strconv.Itoa(dbCfg.StatementTimeout))
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(dbCfg.User, dbCfg.Password),
+		Host:     net.JoinHostPort(dbCfg.Host, dbCfg.Port),
+		Path:     "/" + dbCfg.Database,
+		RawQuery: query.Encode(),
+	}
+
+	return dsn.String()
+}
+
+// connect pings db until it answers, retrying up to retries times with a doubling backoff.
+func connect(ctx context.Context, db *sql.DB, retries int, timeout time.Duration) error {
+	backoff := connectBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := ping(ctx, db, timeout)
+		if err == nil || attempt >= retries {
+			return err
+		}
+
+		logger.Logger.Warn(ctx, "database not reachable, retrying", err.Error(), attempt+1, backoff.String())
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+func ping(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return db.PingContext(ctx)
+}
+
+// Close closes the connection pools of db and its replicas, waiting for running queries to finish.
+func Close(db *gorm.DB) error {
+	if rs, ok := db.Config.Plugins[replicasPluginName].(*replicas); ok {
+		err := rs.close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return closePool(db)
+}
+
+func closePool(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
+
 // GetGormDBFromContext returns gorm db from context.
 func GetGormDBFromContext(ctx context.Context, db *gorm.DB) *gorm.DB {
-	if ctx.Value(constant.PostgresTxKey) != nil {
-		return ctx.Value(constant.PostgresTxKey).(*gorm.DB)
+	if tx, ok := ctx.Value(constant.TxKey(constant.PostgresTxKey)).(*gorm.DB); ok {
+		return tx
+	}
+
+	return db.WithContext(ctx)
+}
+
+// GetReadDBFromContext returns the db to read from. inside a transaction it is the transaction, so it reads
+// its own writes and keeps its locks, otherwise a replica lagging less than the tolerated staleness, or db
+// itself when there is none.
+func GetReadDBFromContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(constant.TxKey(constant.PostgresTxKey)).(*gorm.DB); ok {
+		return tx
+	}
+
+	if rs, ok := db.Config.Plugins[replicasPluginName].(*replicas); ok {
+		if replica := rs.pick(); replica != nil {
+			return replica.WithContext(ctx)
+		}
 	}
 
 	return db.WithContext(ctx)
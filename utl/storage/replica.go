@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+)
+
+// replicasPluginName registers the replicas on the primary, where GetReadDBFromContext finds them.
+const replicasPluginName = "storage:replicas"
+
+// lagCheckInterval is how often the replication lag of the replicas is measured.
+const lagCheckInterval = 5 * time.Second
+
+// DefaultMaxReplicaLag is the staleness tolerated when none is configured.
+const DefaultMaxReplicaLag = 10 * time.Second
+
+// lagQuery returns the seconds a replica is behind the primary, a replica which replayed everything it received
+// is not behind even when the primary has been idle for a while.
+const lagQuery = `SELECT CASE
+    WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+    ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END`
+
+type replica struct {
+	db *gorm.DB
+	// fresh is set while the replica answers and lags less than the tolerated staleness.
+	fresh int32
+}
+
+// replicas routes reads to the replicas lagging less than maxLag, round robin.
+type replicas struct {
+	list   []*replica
+	next   uint32
+	maxLag time.Duration
+	lag    LagFunc
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (*replicas) Name() string {
+	return replicasPluginName
+}
+
+func (*replicas) Initialize(*gorm.DB) error {
+	return nil
+}
+
+// LagFunc measures how far a replica is behind the primary.
+type LagFunc func(ctx context.Context, db *gorm.DB) (time.Duration, error)
+
+// UseReplicas routes the reads of primary outside of transactions to the replicas lagging at most maxLag behind it,
+// DefaultMaxReplicaLag when not positive. the lag is measured with lag now and every few seconds after, a replica is
+// only read from once it is known to be fresh. the replicas are closed along with primary by Close.
+func UseReplicas(primary *gorm.DB, dbs []*gorm.DB, maxLag time.Duration, lag LagFunc) error {
+	if maxLag <= 0 {
+		maxLag = DefaultMaxReplicaLag
+	}
+
+	rs := &replicas{
+		maxLag: maxLag,
+		lag:    lag,
+		stop:   make(chan struct{}),
+	}
+
+	for _, db := range dbs {
+		rs.list = append(rs.list, &replica{db: db})
+	}
+
+	err := primary.Use(rs)
+	if err != nil {
+		return err
+	}
+
+	rs.check(context.Background())
+
+	rs.wg.Add(1)
+
+	go rs.watch()
+
+	return nil
+}
+
+// openReplicas opens a pool per replica of the database config.
+func openReplicas(dbCfg *config.DB) ([]*gorm.DB, error) {
+	dbs := make([]*gorm.DB, 0, len(dbCfg.Replicas))
+
+	for i, dsn := range dbCfg.Replicas {
+		db, err := open(dsn, dbCfg, fmt.Sprintf("replica_%d", i), nil)
+		if err != nil {
+			closePools(dbs)
+
+			return nil, err
+		}
+
+		dbs = append(dbs, db)
+	}
+
+	return dbs, nil
+}
+
+func closePools(dbs []*gorm.DB) {
+	for _, db := range dbs {
+		_ = closePool(db)
+	}
+}
+
+// pick returns the next fresh replica, nil when none is.
+func (rs *replicas) pick() *gorm.DB {
+	for range rs.list {
+		r := rs.list[int(atomic.AddUint32(&rs.next, 1))%len(rs.list)]
+		if atomic.LoadInt32(&r.fresh) == 1 {
+			return r.db
+		}
+	}
+
+	return nil
+}
+
+func (rs *replicas) watch() {
+	defer rs.wg.Done()
+
+	ticker := time.NewTicker(lagCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rs.stop:
+			return
+		case <-ticker.C:
+			rs.check(context.Background())
+		}
+	}
+}
+
+// check measures the lag of every replica and marks the ones within the tolerated staleness as fresh.
+func (rs *replicas) check(ctx context.Context) {
+	for i, r := range rs.list {
+		lag, err := rs.measure(ctx, r.db)
+
+		fresh := err == nil && lag <= rs.maxLag
+		if fresh {
+			atomic.StoreInt32(&r.fresh, 1)
+
+			continue
+		}
+
+		if atomic.SwapInt32(&r.fresh, 0) == 1 {
+			logger.Logger.Warn(ctx, "replica no longer read from", i, lag.String(), fmt.Sprint(err))
+		}
+	}
+}
+
+// measure returns the lag of a replica, giving up once the next check is due.
+func (rs *replicas) measure(ctx context.Context, db *gorm.DB) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, lagCheckInterval)
+	defer cancel()
+
+	return rs.lag(ctx, db)
+}
+
+// ReplicationLag returns how far a postgres replica is behind the primary it streams from.
+func ReplicationLag(ctx context.Context, db *gorm.DB) (time.Duration, error) {
+	var seconds float64
+
+	err := db.WithContext(ctx).Raw(lagQuery).Scan(&seconds).Error
+
+	return time.Duration(seconds * float64(time.Second)), err
+}
+
+// close stops measuring the lag and closes the replica pools.
+func (rs *replicas) close() error {
+	select {
+	case <-rs.stop:
+	default:
+		close(rs.stop)
+	}
+
+	rs.wg.Wait()
+
+	var closeErr error
+
+	for _, r := range rs.list {
+		err := closePool(r.db)
+		if err != nil {
+			closeErr = err
+		}
+	}
+
+	return closeErr
+}
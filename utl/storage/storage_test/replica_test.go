@@ -0,0 +1,90 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/swagftw/stripe_pay_service/utl/constant"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/storage"
+)
+
+// dryRunDB returns a db which never connects, so it can be told apart without a database.
+func dryRunDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "postgres://postgres@127.0.0.1:1/postgres"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestGetReadDBFromContext(t *testing.T) {
+	logger.InitLogger()
+
+	fresh := dryRunDB(t)
+	stale := dryRunDB(t)
+	down := dryRunDB(t)
+	tx := dryRunDB(t)
+
+	// lags as a replica measured against the primary would report them
+	lags := func(_ context.Context, db *gorm.DB) (time.Duration, error) {
+		switch db {
+		case fresh:
+			return time.Second, nil
+		case stale:
+			return time.Minute, nil
+		}
+
+		return 0, errors.New("connection refused")
+	}
+
+	cases := []struct {
+		name     string
+		replicas []*gorm.DB
+		inTx     bool
+		want     *gorm.DB
+	}{
+		{name: "no replicas"},
+		{name: "fresh replica", replicas: []*gorm.DB{stale, fresh, down}, want: fresh},
+		{name: "every replica stale or down", replicas: []*gorm.DB{stale, down}},
+		{name: "inside a transaction", replicas: []*gorm.DB{fresh}, inTx: true, want: tx},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := dryRunDB(t)
+
+			if tt.replicas != nil {
+				require.NoError(t, storage.UseReplicas(primary, tt.replicas, 10*time.Second, lags))
+
+				defer func() {
+					_ = storage.Close(primary)
+				}()
+			}
+
+			want := tt.want
+			if want == nil {
+				want = primary
+			}
+
+			ctx := context.Background()
+			if tt.inTx {
+				ctx = context.WithValue(ctx, constant.TxKey(constant.PostgresTxKey), tx)
+			}
+
+			for i := 0; i < 3; i++ {
+				got := storage.GetReadDBFromContext(ctx, primary)
+				assert.Same(t, want.Statement.ConnPool, got.Statement.ConnPool)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package stripeclient
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+)
+
+// resolutions tell callers how to resolve an error of a kind raised by stripe.
+var resolutions = map[*fault.Kind]string{
+	fault.ErrCardDeclined:           "ask the customer for another payment method",
+	fault.ErrCardExpired:            "ask the customer for another card",
+	fault.ErrCardInvalid:            "ask the customer to check the card details",
+	fault.ErrCardAuthRequired:       "ask the customer to authenticate the payment",
+	fault.ErrCardProcessing:         "retry the payment",
+	fault.ErrCardDeclineRateLimited: "retry the payment later",
+	fault.ErrInvalidParams:          "check the request params",
+	fault.ErrAmountTooSmall:         "amount too small",
+	fault.ErrAmountTooLarge:         "amount too large",
+	fault.ErrAlreadyCaptured:        "payment intent already captured",
+	fault.ErrAlreadyRefunded:        "payment intent already refunded",
+	fault.ErrUnexpectedState:        "payment intent unexpected state",
+	fault.ErrPaymentNotFound:        "check the payment id",
+	fault.ErrCaptureExpired:         "create a new payment",
+	fault.ErrChargeDisputed:         "wait for the dispute to close",
+	fault.ErrInsufficientBalance:    "top up the account balance",
+	fault.ErrIdempotencyConflict:    "send the request with a new idempotency key",
+	fault.ErrIdempotencyKeyInUse:    "retry once the first request completes",
+	fault.ErrConcurrentUpdate:       "retry the request",
+	fault.ErrStripeRateLimited:      "retry the request later",
+	fault.ErrStripeUnavailable:      "retry the request later",
+}
+
+// codeKinds maps stripe error codes to the catalog, whatever the error type.
+var codeKinds = map[stripe.ErrorCode]*fault.Kind{
+	stripe.ErrorCodeAmountTooSmall:                         fault.ErrAmountTooSmall,
+	stripe.ErrorCodeAmountTooLarge:                         fault.ErrAmountTooLarge,
+	stripe.ErrorCodeChargeAlreadyCaptured:                  fault.ErrAlreadyCaptured,
+	stripe.ErrorCodeChargeAlreadyRefunded:                  fault.ErrAlreadyRefunded,
+	stripe.ErrorCodeChargeExpiredForCapture:                fault.ErrCaptureExpired,
+	stripe.ErrorCodeChargeDisputed:                         fault.ErrChargeDisputed,
+	stripe.ErrorCodePaymentIntentUnexpectedState:           fault.ErrUnexpectedState,
+	stripe.ErrorCodePaymentMethodUnexpectedState:           fault.ErrUnexpectedState,
+	stripe.ErrorCodeBalanceInsufficient:                    fault.ErrInsufficientBalance,
+	stripe.ErrorCodeIdempotencyKeyInUse:                    fault.ErrIdempotencyKeyInUse,
+	stripe.ErrorCodeLockTimeout:                            fault.ErrConcurrentUpdate,
+	stripe.ErrorCodeRateLimit:                              fault.ErrStripeRateLimited,
+	stripe.ErrorCodeResourceMissing:                        fault.ErrPaymentNotFound,
+	stripe.ErrorCodeAPIKeyExpired:                          fault.ErrStripeAuthentication,
+	stripe.ErrorCodePlatformAPIKeyExpired:                  fault.ErrStripeAuthentication,
+	stripe.ErrorCodeSecretKeyRequired:                      fault.ErrStripeAuthentication,
+	stripe.ErrorCodeLivemodeMismatch:                       fault.ErrStripeModeMismatch,
+	stripe.ErrorCodeTestmodeChargesOnly:                    fault.ErrStripeModeMismatch,
+	stripe.ErrorCodeAuthenticationRequired:                 fault.ErrCardAuthRequired,
+	stripe.ErrorCodePaymentIntentAuthenticationFailure:     fault.ErrCardAuthRequired,
+	stripe.ErrorCodePaymentIntentPaymentAttemptFailed:      fault.ErrCardDeclined,
+	stripe.ErrorCodeCardDeclined:                           fault.ErrCardDeclined,
+	stripe.ErrorCodeCardDeclinedRateLimitExceeded:          fault.ErrCardDeclineRateLimited,
+	stripe.ErrorCodeExpiredCard:                            fault.ErrCardExpired,
+	stripe.ErrorCodeProcessingError:                        fault.ErrCardProcessing,
+	stripe.ErrorCodeIncorrectCVC:                           fault.ErrCardInvalid,
+	stripe.ErrorCodeIncorrectNumber:                        fault.ErrCardInvalid,
+	stripe.ErrorCodeIncorrectZip:                           fault.ErrCardInvalid,
+	stripe.ErrorCodeIncorrectAddress:                       fault.ErrCardInvalid,
+	stripe.ErrorCodeInvalidCVC:                             fault.ErrCardInvalid,
+	stripe.ErrorCodeInvalidNumber:                          fault.ErrCardInvalid,
+	stripe.ErrorCodeInvalidExpiryMonth:                     fault.ErrCardInvalid,
+	stripe.ErrorCodeInvalidExpiryYear:                      fault.ErrCardInvalid,
+	stripe.ErrorCodeInvalidCardType:                        fault.ErrCardInvalid,
+	stripe.ErrorCodePaymentIntentIncompatiblePaymentMethod: fault.ErrCardInvalid,
+}
+
+// declineKinds refine card declines by the reason the issuer gave, other reasons are plain declines.
+var declineKinds = map[stripe.DeclineCode]*fault.Kind{
+	stripe.DeclineCodeAuthenticationRequired: fault.ErrCardAuthRequired,
+	stripe.DeclineCodeExpiredCard:            fault.ErrCardExpired,
+	stripe.DeclineCodeIncorrectNumber:        fault.ErrCardInvalid,
+	stripe.DeclineCodeIncorrectCVC:           fault.ErrCardInvalid,
+	stripe.DeclineCodeIncorrectZip:           fault.ErrCardInvalid,
+	stripe.DeclineCodeInvalidCVC:             fault.ErrCardInvalid,
+	stripe.DeclineCodeInvalidExpiryMonth:     fault.ErrCardInvalid,
+	stripe.DeclineCodeInvalidExpiryYear:      fault.ErrCardInvalid,
+	stripe.DeclineCodeInvalidNumber:          fault.ErrCardInvalid,
+	stripe.DeclineCodeProcessingError:        fault.ErrCardProcessing,
+}
+
+// retryableDeclines are declines the issuer may approve when the payment is attempted again.
+var retryableDeclines = map[stripe.DeclineCode]bool{
+	stripe.DeclineCodeIssuerNotAvailable: true,
+	stripe.DeclineCodeReenterTransaction: true,
+	stripe.DeclineCodeTryAgainLater:      true,
+	stripe.DeclineCodeApproveWithID:      true,
+	stripe.DeclineCodeProcessingError:    true,
+}
+
+// mapError turns the error of a stripe call into a catalog error. the stripe code, decline code
+// and rejected param are passed on as details, errors which never reached stripe are retryable.
+func mapError(err error) error {
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		return fault.ErrStripeUnavailable.New("stripeclient", resolutions[fault.ErrStripeUnavailable], err)
+	}
+
+	kind := errorKind(stripeErr)
+
+	res, ok := resolutions[kind]
+	if !ok {
+		res = "something went wrong"
+	}
+
+	mapped := kind.New("stripeclient", res, err).(*fault.HTTPError)
+	mapped.Retryable = kind.Retryable || retryableDeclines[stripeErr.DeclineCode]
+	mapped.Details = errorDetails(stripeErr)
+
+	return mapped
+}
+
+// errorKind picks the kind by the error code first, then by the error type and the http status stripe answered.
+func errorKind(stripeErr *stripe.Error) *fault.Kind {
+	if stripeErr.Code == stripe.ErrorCodeCardDeclined {
+		if kind, ok := declineKinds[stripeErr.DeclineCode]; ok {
+			return kind
+		}
+	}
+
+	if kind, ok := codeKinds[stripeErr.Code]; ok {
+		return kind
+	}
+
+	switch stripeErr.Type {
+	case stripe.ErrorTypeCard:
+		return fault.ErrCardDeclined
+	case stripe.ErrorTypeIdempotency:
+		return fault.ErrIdempotencyConflict
+	case stripe.ErrorTypeAuthentication:
+		return fault.ErrStripeAuthentication
+	case stripe.ErrorTypePermission:
+		return fault.ErrStripePermission
+	case stripe.ErrorTypeRateLimit:
+		return fault.ErrStripeRateLimited
+	case stripe.ErrorTypeAPIConnection, stripe.ErrorTypeAPI:
+		return fault.ErrStripeUnavailable
+	}
+
+	switch {
+	case stripeErr.HTTPStatusCode == http.StatusUnauthorized:
+		return fault.ErrStripeAuthentication
+	case stripeErr.HTTPStatusCode == http.StatusForbidden:
+		return fault.ErrStripePermission
+	case stripeErr.HTTPStatusCode == http.StatusNotFound:
+		return fault.ErrPaymentNotFound
+	case stripeErr.HTTPStatusCode == http.StatusConflict:
+		return fault.ErrConcurrentUpdate
+	case stripeErr.HTTPStatusCode == http.StatusTooManyRequests:
+		return fault.ErrStripeRateLimited
+	case stripeErr.HTTPStatusCode >= http.StatusInternalServerError:
+		return fault.ErrStripeUnavailable
+	}
+
+	return fault.ErrInvalidParams
+}
+
+func errorDetails(stripeErr *stripe.Error) map[string]string {
+	details := make(map[string]string)
+
+	for key, value := range map[string]string{
+		"stripeCode":      string(stripeErr.Code),
+		"declineCode":     string(stripeErr.DeclineCode),
+		"param":           stripeErr.Param,
+		"stripeRequestId": stripeErr.RequestID,
+	} {
+		if value != "" {
+			details[key] = value
+		}
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+
+	return details
+}
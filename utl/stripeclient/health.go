@@ -0,0 +1,33 @@
+package stripeclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Ping checks that the stripe api at url, stripe.APIURL outside of tests, can be reached.
+// any answer below 500 counts, unauthenticated requests are answered with 401.
+func Ping(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/v1", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("stripe api answered %s", res.Status)
+	}
+
+	return nil
+}
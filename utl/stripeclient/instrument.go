@@ -0,0 +1,46 @@
+package stripeclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/swagftw/stripe_pay_service/utl/metrics"
+	"github.com/swagftw/stripe_pay_service/utl/tracing"
+)
+
+// stripe operations used as span names and metric labels.
+const (
+	opCreatePaymentIntent  = "payment_intents.create"
+	opConfirmPaymentIntent = "payment_intents.confirm"
+	opCapturePaymentIntent = "payment_intents.capture"
+	opListPaymentIntents   = "payment_intents.list"
+	opCreateRefund         = "refunds.create"
+)
+
+// observe starts a span for a stripe call, the returned func ends it and records the call duration,
+// counting the call as failed when err is set.
+func observe(ctx context.Context, operation string) func(err error) {
+	start := time.Now()
+
+	_, span := tracing.Start(ctx, "stripe."+operation, trace.WithSpanKind(trace.SpanKindClient))
+
+	return func(err error) {
+		metrics.StripeDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			errType := "unknown"
+			if stripeErr, ok := err.(*stripe.Error); ok {
+				errType = string(stripeErr.Type)
+				span.SetAttributes(attribute.String("stripe.error.type", errType), attribute.String("stripe.error.code", string(stripeErr.Code)))
+			}
+
+			metrics.StripeErrors.WithLabelValues(operation, errType).Inc()
+		}
+
+		tracing.End(span, err)
+	}
+}
@@ -0,0 +1,65 @@
+package stripeclient
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/swagftw/stripe_pay_service/utl/config"
+)
+
+// stripe modes, test keys only reach test data and live keys move real money.
+const (
+	ModeTest = "test"
+	ModeLive = "live"
+)
+
+// ErrModeMismatch is returned when a stripe key of the wrong mode is used for the environment.
+var ErrModeMismatch = errors.New("stripe key mode does not match the environment")
+
+// Mode returns the mode of a stripe secret, restricted or publishable key from its prefix.
+func Mode(key string) (string, error) {
+	for _, kind := range []string{"sk_", "rk_", "pk_"} {
+		switch {
+		case strings.HasPrefix(key, kind+"live_"):
+			return ModeLive, nil
+		case strings.HasPrefix(key, kind+"test_"):
+			return ModeTest, nil
+		}
+	}
+
+	return "", fmt.Errorf("stripe key %s... is neither a test nor a live key", prefix(key))
+}
+
+// ModeFor returns the mode of the keys an environment must use, live in prod and test everywhere else.
+func ModeFor(environment string) string {
+	if environment == config.EnvironmentProd {
+		return ModeLive
+	}
+
+	return ModeTest
+}
+
+// CheckMode checks that key is of the mode environment must use.
+func CheckMode(environment, key string) error {
+	mode, err := Mode(key)
+	if err != nil {
+		return err
+	}
+
+	if mode != ModeFor(environment) {
+		return fmt.Errorf("%w: %s key %s... used in %s, which needs %s keys", ErrModeMismatch, mode, prefix(key), environment, ModeFor(environment))
+	}
+
+	return nil
+}
+
+// prefix returns the kind and mode part of a key, the part safe to log.
+func prefix(key string) string {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+
+	return parts[0] + "_" + parts[1] + "_"
+}
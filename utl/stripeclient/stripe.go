@@ -3,6 +3,8 @@ package stripeclient
 import (
 	"context"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jinzhu/copier"
@@ -16,22 +18,122 @@ import (
 )
 
 type stripeClient struct {
+	// client is used for every request when the service talks to a single stripe account.
 	client *client.API
+	// configured follows the secret key of the stripe config, the client is rebuilt once the key is rotated.
+	configured bool
+	secretKey  string
+
+	// keys resolves per merchant stripe accounts, clients are cached per merchant with the key they were built with.
+	keys    KeyResolver
+	mutex   sync.Mutex
+	clients map[string]merchantClient
+}
+
+// merchantClient is the client of a merchant along with the secret key it was built with.
+type merchantClient struct {
+	api       *client.API
+	secretKey string
 }
 
 type StripeService interface {
-	CreatePaymentIntent(req *types.CreateIntentReq) (*types.CreateIntentRes, error)
-	CapturePaymentIntent(paymentID string, amount int) (*types.CaptureIntentRes, error)
-	GetAllPaymentIntents() ([]*types.PaymentIntent, error)
-	CreateRefund(paymentID string, amount int) (*types.CreateRefundRes, error)
+	CreatePaymentIntent(ctx context.Context, req *types.CreateIntentReq) (*types.CreateIntentRes, error)
+	CapturePaymentIntent(ctx context.Context, paymentID string, amount int) (*types.CaptureIntentRes, error)
+	GetAllPaymentIntents(ctx context.Context) ([]*types.PaymentIntent, error)
+	CreateRefund(ctx context.Context, paymentID string, amount int) (*types.CreateRefundRes, error)
+}
+
+// KeyResolver resolves the stripe secret key of a merchant.
+type KeyResolver interface {
+	StripeSecretKey(ctx context.Context, merchantID string) (string, error)
 }
 
+// New returns a stripe service for the single account configured in the stripe config,
+// calls made after the secret key was rotated use the new key.
 func New() StripeService {
-	stripeCfg := config.GetGlobalConfig().GetStripeConfig()
+	return &stripeClient{
+		configured: true,
+	}
+}
 
+// NewForMerchants returns a stripe service which calls stripe with the account of the merchant on the context.
+// the key of the merchant is resolved on every call, so calls made after it was rotated use the new key.
+func NewForMerchants(keys KeyResolver) StripeService {
 	return &stripeClient{
-		client: client.New(stripeCfg.SecretKey, nil),
+		keys:    keys,
+		clients: make(map[string]merchantClient),
+	}
+}
+
+// api returns the stripe client to use for the request.
+func (sc *stripeClient) api(ctx context.Context) (*client.API, error) {
+	if sc.keys == nil {
+		return sc.accountClient()
+	}
+
+	merchantID, ok := types.MerchantIDFromContext(ctx)
+	if !ok {
+		return nil, fault.ErrMerchantRequired.New("stripeclient", "authenticate as a merchant", types.ErrMerchantRequired)
+	}
+
+	secretKey, err := sc.keys.StripeSecretKey(ctx, merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	cached, ok := sc.clients[merchantID]
+	if ok && cached.secretKey == secretKey {
+		return cached.api, nil
+	}
+
+	// a key rotated to the wrong mode is refused rather than used
+	err = checkMode(secretKey)
+	if err != nil {
+		return nil, err
 	}
+
+	api := client.New(secretKey, nil)
+	sc.clients[merchantID] = merchantClient{api: api, secretKey: secretKey}
+
+	return api, nil
+}
+
+// accountClient returns the client of the single account, rebuilt when the configured secret key changed.
+func (sc *stripeClient) accountClient() (*client.API, error) {
+	if !sc.configured {
+		return sc.client, nil
+	}
+
+	secretKey := config.GetGlobalConfig().GetStripeSecretKey()
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if sc.client == nil || secretKey != sc.secretKey {
+		// a key rotated to the wrong mode is refused rather than used
+		err := checkMode(secretKey)
+		if err != nil {
+			return nil, err
+		}
+
+		sc.client = client.New(secretKey, nil)
+		sc.secretKey = secretKey
+	}
+
+	return sc.client, nil
+}
+
+// checkMode refuses keys whose mode does not match the configured environment.
+func checkMode(secretKey string) error {
+	err := CheckMode(config.GetGlobalConfig().GetServerConfig().Environment, secretKey)
+	if err != nil {
+		return fault.ErrStripeModeMismatch.New("stripeclient", "something went wrong", err)
+	}
+
+	return nil
 }
 
 func NewMock() StripeService {
@@ -49,10 +151,20 @@ func NewMock() StripeService {
 }
 
 // CreatePaymentIntent creates payment intent on stripe and sends back the response.
-func (sc *stripeClient) CreatePaymentIntent(req *types.CreateIntentReq) (*types.CreateIntentRes, error) {
+func (sc *stripeClient) CreatePaymentIntent(ctx context.Context, req *types.CreateIntentReq) (*types.CreateIntentRes, error) {
+	api, err := sc.api(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currency := stripe.CurrencyINR
+	if req.Currency != "" {
+		currency = stripe.Currency(strings.ToLower(req.Currency))
+	}
+
 	intent := &stripe.PaymentIntentParams{
 		Amount:       &req.Amount,
-		Currency:     stripe.String(string(stripe.CurrencyINR)),
+		Currency:     stripe.String(string(currency)),
 		Description:  &req.Description,
 		ReceiptEmail: &req.Email,
 		PaymentMethodTypes: []*string{
@@ -61,20 +173,15 @@ func (sc *stripeClient) CreatePaymentIntent(req *types.CreateIntentReq) (*types.
 		CaptureMethod: stripe.String("manual"),
 	}
 
-	stripeIntent, err := sc.client.PaymentIntents.New(intent)
+	done := observe(ctx, opCreatePaymentIntent)
+	stripeIntent, err := api.PaymentIntents.New(intent)
+	done(err)
+
 	if err != nil {
 		msg := "source:stripe, message:error creating payment intent"
-		logger.Logger.Error(context.TODO(), msg, err)
-
-		if stripeErr, ok := err.(*stripe.Error); ok {
-			if stripeErr.Code == stripe.ErrorCodePaymentIntentInvalidParameter {
-				return nil, fault.New(http.StatusBadRequest, "stripeclient", "error creating payment intent", "check the request params", "ERR_INVALID_PARAMS", err)
-			} else if stripeErr.Code == stripe.ErrorCodeAmountTooSmall {
-				return nil, fault.New(http.StatusBadRequest, "stripeclient", "error creating payment intent", "amount too small", "ERR_AMOUNT_TOO_SMALL", err)
-			}
-		}
+		logger.Logger.Error(ctx, msg, err)
 
-		return nil, err
+		return nil, mapError(err)
 	}
 
 	res := new(types.CreateIntentRes)
@@ -82,45 +189,47 @@ func (sc *stripeClient) CreatePaymentIntent(req *types.CreateIntentReq) (*types.
 	err = copier.Copy(res, stripeIntent)
 	if err != nil {
 		msg := "source:copier, message: error copying payment intent"
-		logger.Logger.Error(context.TODO(), msg, err)
+		logger.Logger.Error(ctx, msg, err)
 
-		return nil, fault.New(http.StatusInternalServerError, "stripeclient", "error creating copying data", "something went wrong", "ERR_INTERNAL_SERVER_ERROR", err)
+		return nil, fault.ErrInternal.New("stripeclient", "something went wrong", err)
 	}
 
 	return res, nil
 }
 
 // CapturePaymentIntent captures a payment intent.
-func (sc *stripeClient) CapturePaymentIntent(paymentID string, amount int) (*types.CaptureIntentRes, error) {
+func (sc *stripeClient) CapturePaymentIntent(ctx context.Context, paymentID string, amount int) (*types.CaptureIntentRes, error) {
+	api, err := sc.api(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// update the payment intent with payment method first
-	_, err := sc.client.PaymentIntents.Confirm(paymentID, &stripe.PaymentIntentConfirmParams{
+	done := observe(ctx, opConfirmPaymentIntent)
+	_, err = api.PaymentIntents.Confirm(paymentID, &stripe.PaymentIntentConfirmParams{
 		PaymentMethod: stripe.String("pm_card_visa"),
 	})
+	done(err)
+
 	if err != nil {
 		msg := "source:stripe, message:error updating payment intent"
-		logger.Logger.Error(context.TODO(), msg, err)
-		if stripeErr, ok := err.(*stripe.Error); ok {
-			if stripeErr.Code == stripe.ErrorCodePaymentIntentInvalidParameter {
-				return nil, fault.New(http.StatusBadRequest, "stripeclient", "error updating payment intent", "check the request params", "ERR_INVALID_PARAMS", err)
-			} else if stripeErr.Code == stripe.ErrorCodeChargeAlreadyCaptured {
-				return nil, fault.New(http.StatusBadRequest, "stripeclient", "error updating payment intent", "payment intent already captured", "ERR_ALREADY_CAPTURED", err)
-			} else if stripeErr.Code == stripe.ErrorCodePaymentIntentUnexpectedState {
-				return nil, fault.New(http.StatusBadRequest, "stripeclient", "error updating payment intent", "payment intent unexpected state", "ERR_UNEXPECTED_STATE", err)
-			}
-		}
+		logger.Logger.Error(ctx, msg, err)
 
-		return nil, err
+		return nil, mapError(err)
 	}
 
 	// capture the payment intent using amount
-	paymentIntent, err := sc.client.PaymentIntents.Capture(paymentID, &stripe.PaymentIntentCaptureParams{
+	done = observe(ctx, opCapturePaymentIntent)
+	paymentIntent, err := api.PaymentIntents.Capture(paymentID, &stripe.PaymentIntentCaptureParams{
 		AmountToCapture: stripe.Int64(int64(amount)),
 	})
+	done(err)
+
 	if err != nil {
 		msg := "source:stripe, message:error capturing payment intent"
-		logger.Logger.Error(context.TODO(), msg, err)
+		logger.Logger.Error(ctx, msg, err)
 
-		return nil, err
+		return nil, mapError(err)
 	}
 
 	res := new(types.CaptureIntentRes)
@@ -128,57 +237,87 @@ func (sc *stripeClient) CapturePaymentIntent(paymentID string, amount int) (*typ
 	err = copier.Copy(res, paymentIntent)
 	if err != nil {
 		msg := "source:copier, message: error copying payment intent"
-		logger.Logger.Error(context.TODO(), msg, err)
+		logger.Logger.Error(ctx, msg, err)
 
-		return nil, err
+		return nil, fault.ErrInternal.New("stripeclient", "something went wrong", err)
 	}
 
 	return res, nil
 }
 
 // GetAllPaymentIntents returns all payment intents.
-func (sc *stripeClient) GetAllPaymentIntents() ([]*types.PaymentIntent, error) {
+func (sc *stripeClient) GetAllPaymentIntents(ctx context.Context) ([]*types.PaymentIntent, error) {
+	api, err := sc.api(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	resp := make([]*types.PaymentIntent, 0)
 
 	params := &stripe.PaymentIntentListParams{}
 	params.Filters.AddFilter("limit", "", "10")
-	i := sc.client.PaymentIntents.List(params)
+
+	done := observe(ctx, opListPaymentIntents)
+	i := api.PaymentIntents.List(params)
 
 	for i.Next() {
 		paymentIntent := new(types.PaymentIntent)
-		intent := i.PaymentIntent()
-		_ = copier.Copy(paymentIntent, intent)
+
+		err = copier.Copy(paymentIntent, i.PaymentIntent())
+		if err != nil {
+			done(nil)
+
+			msg := "source:copier, message: error copying payment intent"
+			logger.Logger.Error(ctx, msg, err)
+
+			return nil, fault.ErrInternal.New("stripeclient", "something went wrong", err)
+		}
+
 		resp = append(resp, paymentIntent)
 	}
 
+	err = i.Err()
+	done(err)
+
+	if err != nil {
+		msg := "source:stripe, message:error listing payment intents"
+		logger.Logger.Error(ctx, msg, err)
+
+		return nil, mapError(err)
+	}
+
 	return resp, nil
 }
 
 // CreateRefund creates a refund on stripe and sends back the response.
-func (sc *stripeClient) CreateRefund(paymentID string, amount int) (*types.CreateRefundRes, error) {
-	refund, err := sc.client.Refunds.New(&stripe.RefundParams{
+func (sc *stripeClient) CreateRefund(ctx context.Context, paymentID string, amount int) (*types.CreateRefundRes, error) {
+	api, err := sc.api(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := observe(ctx, opCreateRefund)
+	refund, err := api.Refunds.New(&stripe.RefundParams{
 		Amount:        stripe.Int64(int64(amount)),
 		PaymentIntent: stripe.String(paymentID),
 	})
+	done(err)
 
 	if err != nil {
 		msg := "source:stripe, message:error creating refund"
-		logger.Logger.Error(context.TODO(), msg, err)
+		logger.Logger.Error(ctx, msg, err)
 
-		if stripeErr, ok := err.(*stripe.Error); ok {
-			if stripeErr.Code == stripe.ErrorCodeChargeAlreadyRefunded {
-				return nil, fault.New(http.StatusBadRequest, "stripeclient", "error creating refund", "payment intent already refunded", "ERR_ALREADY_REFUNDED", err)
-			}
-		}
-
-		return nil, err
+		return nil, mapError(err)
 	}
 
 	resp := new(types.CreateRefundRes)
 
 	err = copier.Copy(resp, refund)
 	if err != nil {
-		return nil, err
+		msg := "source:copier, message: error copying refund"
+		logger.Logger.Error(ctx, msg, err)
+
+		return nil, fault.ErrInternal.New("stripeclient", "something went wrong", err)
 	}
 
 	return resp, nil
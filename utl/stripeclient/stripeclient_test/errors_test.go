@@ -0,0 +1,177 @@
+package stripeclient_test_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/swagftw/stripe_pay_service/types"
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
+	"github.com/swagftw/stripe_pay_service/utl/logger"
+	"github.com/swagftw/stripe_pay_service/utl/stripeclient"
+)
+
+func TestErrorMapping(t *testing.T) {
+	logger.InitLogger()
+
+	err := config.InitConfig("../../config/config.local.yaml", "../../../.env", encryptionKeyOverride)
+	require.NoError(t, err)
+
+	config.GetGlobalConfig().SetStripeSecretKey("sk_test_errors")
+
+	cases := []struct {
+		name          string
+		status        int
+		body          string
+		down          bool
+		wantKind      *fault.Kind
+		wantStatus    int
+		wantRetryable bool
+		wantDetails   map[string]string
+	}{
+		{
+			name:        "generic decline",
+			status:      http.StatusPaymentRequired,
+			body:        `{"error":{"type":"card_error","code":"card_declined","decline_code":"insufficient_funds"}}`,
+			wantKind:    fault.ErrCardDeclined,
+			wantStatus:  http.StatusPaymentRequired,
+			wantDetails: map[string]string{"stripeCode": "card_declined", "declineCode": "insufficient_funds"},
+		},
+		{
+			name:          "decline worth retrying",
+			status:        http.StatusPaymentRequired,
+			body:          `{"error":{"type":"card_error","code":"card_declined","decline_code":"try_again_later"}}`,
+			wantKind:      fault.ErrCardDeclined,
+			wantStatus:    http.StatusPaymentRequired,
+			wantRetryable: true,
+			wantDetails:   map[string]string{"stripeCode": "card_declined", "declineCode": "try_again_later"},
+		},
+		{
+			name:        "decline by an expired card",
+			status:      http.StatusPaymentRequired,
+			body:        `{"error":{"type":"card_error","code":"card_declined","decline_code":"expired_card"}}`,
+			wantKind:    fault.ErrCardExpired,
+			wantStatus:  http.StatusPaymentRequired,
+			wantDetails: map[string]string{"stripeCode": "card_declined", "declineCode": "expired_card"},
+		},
+		{
+			name:        "incorrect cvc",
+			status:      http.StatusPaymentRequired,
+			body:        `{"error":{"type":"card_error","code":"incorrect_cvc","param":"cvc"}}`,
+			wantKind:    fault.ErrCardInvalid,
+			wantStatus:  http.StatusPaymentRequired,
+			wantDetails: map[string]string{"stripeCode": "incorrect_cvc", "param": "cvc"},
+		},
+		{
+			name:        "amount too small",
+			status:      http.StatusBadRequest,
+			body:        `{"error":{"type":"invalid_request_error","code":"amount_too_small","param":"amount"}}`,
+			wantKind:    fault.ErrAmountTooSmall,
+			wantStatus:  http.StatusBadRequest,
+			wantDetails: map[string]string{"stripeCode": "amount_too_small", "param": "amount"},
+		},
+		{
+			name:        "unknown invalid request",
+			status:      http.StatusBadRequest,
+			body:        `{"error":{"type":"invalid_request_error","code":"parameter_unknown","param":"colour"}}`,
+			wantKind:    fault.ErrInvalidParams,
+			wantStatus:  http.StatusBadRequest,
+			wantDetails: map[string]string{"stripeCode": "parameter_unknown", "param": "colour"},
+		},
+		{
+			name:       "invalid api key",
+			status:     http.StatusUnauthorized,
+			body:       `{"error":{"type":"invalid_request_error","message":"Invalid API Key provided"}}`,
+			wantKind:   fault.ErrStripeAuthentication,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "missing permission",
+			status:     http.StatusForbidden,
+			body:       `{"error":{"type":"invalid_request_error"}}`,
+			wantKind:   fault.ErrStripePermission,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "idempotency key reused",
+			status:     http.StatusBadRequest,
+			body:       `{"error":{"type":"idempotency_error"}}`,
+			wantKind:   fault.ErrIdempotencyConflict,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:          "idempotency key in use",
+			status:        http.StatusConflict,
+			body:          `{"error":{"type":"invalid_request_error","code":"idempotency_key_in_use"}}`,
+			wantKind:      fault.ErrIdempotencyKeyInUse,
+			wantStatus:    http.StatusConflict,
+			wantRetryable: true,
+			wantDetails:   map[string]string{"stripeCode": "idempotency_key_in_use"},
+		},
+		{
+			name:          "rate limited",
+			status:        http.StatusTooManyRequests,
+			body:          `{"error":{"type":"invalid_request_error","code":"rate_limit"}}`,
+			wantKind:      fault.ErrStripeRateLimited,
+			wantStatus:    http.StatusServiceUnavailable,
+			wantRetryable: true,
+			wantDetails:   map[string]string{"stripeCode": "rate_limit"},
+		},
+		{
+			name:          "stripe outage",
+			status:        http.StatusInternalServerError,
+			body:          `{"error":{"type":"api_error"}}`,
+			wantKind:      fault.ErrStripeUnavailable,
+			wantStatus:    http.StatusBadGateway,
+			wantRetryable: true,
+		},
+		{
+			name:          "stripe unreachable",
+			down:          true,
+			wantKind:      fault.ErrStripeUnavailable,
+			wantStatus:    http.StatusBadGateway,
+			wantRetryable: true,
+		},
+	}
+
+	previous := stripe.GetBackend(stripe.APIBackend)
+	defer stripe.SetBackend(stripe.APIBackend, previous)
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			if tt.down {
+				srv.Close()
+			} else {
+				defer srv.Close()
+			}
+
+			stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+				URL:               stripe.String(srv.URL),
+				MaxNetworkRetries: stripe.Int64(0),
+			}))
+
+			_, err := stripeclient.New().CreatePaymentIntent(context.TODO(), &types.CreateIntentReq{Amount: 100})
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tt.wantKind), "got %v", err)
+
+			var httpErr *fault.HTTPError
+
+			require.ErrorAs(t, err, &httpErr)
+			assert.Equal(t, tt.wantStatus, httpErr.Status)
+			assert.Equal(t, tt.wantRetryable, httpErr.Retryable)
+			assert.Equal(t, tt.wantDetails, httpErr.Details)
+		})
+	}
+}
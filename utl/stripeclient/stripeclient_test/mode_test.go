@@ -0,0 +1,46 @@
+package stripeclient_test_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/stripeclient"
+)
+
+func TestCheckMode(t *testing.T) {
+	cases := []struct {
+		name        string
+		environment string
+		key         string
+		wantMode    string
+		wantErr     bool
+		mismatch    bool
+	}{
+		{name: "test key in dev", environment: config.EnvironmentDev, key: "sk_test_abc", wantMode: stripeclient.ModeTest},
+		{name: "restricted test key in staging", environment: config.EnvironmentStaging, key: "rk_test_abc", wantMode: stripeclient.ModeTest},
+		{name: "live key in prod", environment: config.EnvironmentProd, key: "sk_live_abc", wantMode: stripeclient.ModeLive},
+		{name: "live publishable key in prod", environment: config.EnvironmentProd, key: "pk_live_abc", wantMode: stripeclient.ModeLive},
+		{name: "live key in dev", environment: config.EnvironmentDev, key: "rk_live_abc", wantMode: stripeclient.ModeLive, wantErr: true, mismatch: true},
+		{name: "test key in prod", environment: config.EnvironmentProd, key: "sk_test_abc", wantMode: stripeclient.ModeTest, wantErr: true, mismatch: true},
+		{name: "unknown key", environment: config.EnvironmentDev, key: "whsec_abc", wantErr: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, _ := stripeclient.Mode(tt.key)
+			assert.Equal(t, tt.wantMode, mode)
+
+			err := stripeclient.CheckMode(tt.environment, tt.key)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.mismatch, errors.Is(err, stripeclient.ErrModeMismatch))
+
+			// the secret part of the key is never part of the error
+			if err != nil {
+				assert.NotContains(t, err.Error(), "abc")
+			}
+		})
+	}
+}
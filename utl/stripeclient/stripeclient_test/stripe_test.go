@@ -1,20 +1,29 @@
 package stripeclient_test_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v72"
 
 	"github.com/swagftw/stripe_pay_service/types"
 	"github.com/swagftw/stripe_pay_service/utl/config"
+	"github.com/swagftw/stripe_pay_service/utl/fault"
 	"github.com/swagftw/stripe_pay_service/utl/logger"
 	"github.com/swagftw/stripe_pay_service/utl/stripeclient"
 )
 
+// encryptionKeyOverride sets the encryption key the config requires, .env leaves it empty.
+const encryptionKeyOverride = "security.encryptionKey=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
 func TestGetAllPaymentIntents(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../config/config.local.yaml", "../../../.env")
+	err := config.InitConfig("../../config/config.local.yaml", "../../../.env", encryptionKeyOverride)
 	if err != nil {
 		panic(err)
 	}
@@ -33,7 +42,7 @@ func TestGetAllPaymentIntents(t *testing.T) {
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := tt.stripeService.GetAllPaymentIntents()
+			_, err := tt.stripeService.GetAllPaymentIntents(context.TODO())
 			assert.Equal(t, tt.wantErr, err != nil)
 		})
 	}
@@ -42,7 +51,7 @@ func TestGetAllPaymentIntents(t *testing.T) {
 func TestCreatePaymentIntent(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../config/config.local.yaml", "../../../.env")
+	err := config.InitConfig("../../config/config.local.yaml", "../../../.env", encryptionKeyOverride)
 	if err != nil {
 		panic(err)
 	}
@@ -76,7 +85,7 @@ func TestCreatePaymentIntent(t *testing.T) {
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := tt.stripeService.CreatePaymentIntent(tt.data)
+			_, err := tt.stripeService.CreatePaymentIntent(context.TODO(), tt.data)
 			assert.Equal(t, tt.wantErr, err != nil)
 		})
 	}
@@ -85,7 +94,7 @@ func TestCreatePaymentIntent(t *testing.T) {
 func TestCapturePaymentIntent(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../config/config.local.yaml", "../../../.env")
+	err := config.InitConfig("../../config/config.local.yaml", "../../../.env", encryptionKeyOverride)
 	if err != nil {
 		panic(err)
 	}
@@ -116,7 +125,7 @@ func TestCapturePaymentIntent(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.name == "success" {
-				intent, err := tt.stripeService.CreatePaymentIntent(&types.CreateIntentReq{
+				intent, err := tt.stripeService.CreatePaymentIntent(context.TODO(), &types.CreateIntentReq{
 					Amount:      100,
 					Email:       "asd@asd.com",
 					Phone:       "",
@@ -128,12 +137,12 @@ func TestCapturePaymentIntent(t *testing.T) {
 
 				tt.paymentID = intent.ID
 
-				_, err = tt.stripeService.CapturePaymentIntent(tt.paymentID, tt.amount)
+				_, err = tt.stripeService.CapturePaymentIntent(context.TODO(), tt.paymentID, tt.amount)
 				assert.Equal(t, tt.wantErr, err != nil)
 
 				return
 			}
-			_, err := stripeclient.New().CapturePaymentIntent(tt.paymentID, tt.amount)
+			_, err := stripeclient.New().CapturePaymentIntent(context.TODO(), tt.paymentID, tt.amount)
 			assert.Equal(t, tt.wantErr, err != nil)
 		})
 	}
@@ -142,7 +151,7 @@ func TestCapturePaymentIntent(t *testing.T) {
 func TestCreateRefund(t *testing.T) {
 	logger.InitLogger()
 
-	err := config.InitConfig("../../config/config.local.yaml", "../../../.env")
+	err := config.InitConfig("../../config/config.local.yaml", "../../../.env", encryptionKeyOverride)
 	if err != nil {
 		panic(err)
 	}
@@ -170,7 +179,7 @@ func TestCreateRefund(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.name == "success" {
-				intent, err := tt.stripeService.CreatePaymentIntent(&types.CreateIntentReq{
+				intent, err := tt.stripeService.CreatePaymentIntent(context.TODO(), &types.CreateIntentReq{
 					Amount:      100,
 					Email:       "asd@asd.com",
 					Phone:       "",
@@ -183,17 +192,174 @@ func TestCreateRefund(t *testing.T) {
 				tt.paymentID = intent.ID
 				tt.amount = intent.Amount
 
-				_, err = tt.stripeService.CapturePaymentIntent(tt.paymentID, tt.amount)
+				_, err = tt.stripeService.CapturePaymentIntent(context.TODO(), tt.paymentID, tt.amount)
 				assert.Equal(t, tt.wantErr, err != nil)
 
-				_, err = tt.stripeService.CreateRefund(tt.paymentID, tt.amount)
+				_, err = tt.stripeService.CreateRefund(context.TODO(), tt.paymentID, tt.amount)
 				assert.Equal(t, tt.wantErr, err != nil)
 
 				return
 			}
 
-			_, err := tt.stripeService.CreateRefund(tt.paymentID, tt.amount)
+			_, err := tt.stripeService.CreateRefund(context.TODO(), tt.paymentID, tt.amount)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "unauthenticated answer is reachable", status: http.StatusUnauthorized},
+		{name: "stripe outage", status: http.StatusServiceUnavailable, wantErr: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			err := stripeclient.Ping(context.TODO(), srv.URL)
 			assert.Equal(t, tt.wantErr, err != nil)
 		})
 	}
 }
+
+func TestSecretKeyRotation(t *testing.T) {
+	logger.InitLogger()
+
+	err := config.InitConfig("../../config/config.local.yaml", "../../../.env", encryptionKeyOverride)
+	if err != nil {
+		panic(err)
+	}
+
+	var authorizations []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"object":"list","data":[],"has_more":false}`))
+	}))
+	defer srv.Close()
+
+	// clients built without backends call the default api backend
+	previous := stripe.GetBackend(stripe.APIBackend)
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{URL: stripe.String(srv.URL)}))
+
+	defer stripe.SetBackend(stripe.APIBackend, previous)
+
+	stripeService := stripeclient.New()
+
+	config.GetGlobalConfig().SetStripeSecretKey("rk_test_old")
+
+	_, err = stripeService.GetAllPaymentIntents(context.TODO())
+	assert.NoError(t, err)
+
+	config.GetGlobalConfig().SetStripeSecretKey("rk_test_new")
+
+	_, err = stripeService.GetAllPaymentIntents(context.TODO())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"Bearer rk_test_old", "Bearer rk_test_new"}, authorizations)
+}
+
+// merchantKeys resolves the stripe secret keys of merchants from a map, which tests rotate keys in.
+type merchantKeys struct {
+	mutex sync.Mutex
+	keys  map[string]string
+}
+
+func (m *merchantKeys) StripeSecretKey(_ context.Context, merchantID string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.keys[merchantID], nil
+}
+
+func (m *merchantKeys) set(merchantID, key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.keys[merchantID] = key
+}
+
+func TestMerchantSecretKeyRotation(t *testing.T) {
+	logger.InitLogger()
+
+	err := config.InitConfig("../../config/config.local.yaml", "../../../.env", encryptionKeyOverride)
+	if err != nil {
+		panic(err)
+	}
+
+	var authorizations []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"object":"list","data":[],"has_more":false}`))
+	}))
+	defer srv.Close()
+
+	previous := stripe.GetBackend(stripe.APIBackend)
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{URL: stripe.String(srv.URL)}))
+
+	defer stripe.SetBackend(stripe.APIBackend, previous)
+
+	keys := &merchantKeys{keys: map[string]string{"mr_1": "rk_test_old", "mr_2": "rk_test_other"}}
+	stripeService := stripeclient.NewForMerchants(keys)
+
+	merchant1 := types.WithPrincipal(context.TODO(), &types.Principal{ClientID: "mr_1", MerchantID: "mr_1"})
+	merchant2 := types.WithPrincipal(context.TODO(), &types.Principal{ClientID: "mr_2", MerchantID: "mr_2"})
+
+	_, err = stripeService.GetAllPaymentIntents(merchant1)
+	assert.NoError(t, err)
+
+	keys.set("mr_1", "rk_test_new")
+
+	_, err = stripeService.GetAllPaymentIntents(merchant1)
+	assert.NoError(t, err)
+
+	_, err = stripeService.GetAllPaymentIntents(merchant2)
+	assert.NoError(t, err)
+
+	// a key rotated to the wrong mode is refused, the old client is not used instead
+	keys.set("mr_1", "rk_live_new")
+
+	_, err = stripeService.GetAllPaymentIntents(merchant1)
+	assert.ErrorIs(t, err, fault.ErrStripeModeMismatch)
+
+	assert.Equal(t, []string{"Bearer rk_test_old", "Bearer rk_test_new", "Bearer rk_test_other"}, authorizations)
+}
+
+func TestGetAllPaymentIntentsError(t *testing.T) {
+	logger.InitLogger()
+
+	err := config.InitConfig("../../config/config.local.yaml", "../../../.env", encryptionKeyOverride)
+	if err != nil {
+		panic(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":{"type":"invalid_request_error","message":"Invalid API Key provided"}}`))
+	}))
+	defer srv.Close()
+
+	previous := stripe.GetBackend(stripe.APIBackend)
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:               stripe.String(srv.URL),
+		MaxNetworkRetries: stripe.Int64(0),
+	}))
+
+	defer stripe.SetBackend(stripe.APIBackend, previous)
+
+	config.GetGlobalConfig().SetStripeSecretKey("rk_test_revoked")
+
+	// the failed list is not answered as an empty one
+	intents, err := stripeclient.New().GetAllPaymentIntents(context.TODO())
+	assert.Nil(t, intents)
+	assert.ErrorIs(t, err, fault.ErrStripeAuthentication)
+}